@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+)
+
+// check validates the config file and its assets offline. It returns the
+// exit code: 1 if errors were found, 0 otherwise.
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	file := fs.String("c", "./config.yml", "`path` to config.yml configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s check:\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Validates the configuration, system configs, templates, firmware images and SSH keys.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var errors, warnings int
+	for _, issue := range provisioner.CheckConfig(*file) {
+		fmt.Println(issue)
+		if issue.Warning {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	fmt.Printf("%s: %d error(s), %d warning(s)\n", *file, errors, warnings)
+
+	if errors > 0 {
+		return 1
+	}
+	return 0
+}
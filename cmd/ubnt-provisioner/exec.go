@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/digineo/ubnt-tools/provisioner"
+)
+
+// execReportJSON is the JSON presentation of a provisioner.ExecReport.
+type execReportJSON struct {
+	MacAddress string  `json:"mac_address"`
+	Hostname   string  `json:"hostname"`
+	Model      string  `json:"model"`
+	Firmware   string  `json:"firmware"`
+	IPAddress  string  `json:"ip_address"`
+	ExitCode   int     `json:"exit_code"`
+	Duration   float64 `json:"duration"` // seconds
+	Stdout     string  `json:"stdout"`
+	Stderr     string  `json:"stderr"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// execFleet runs a command on all discovered devices matching the filter
+// flags, and prints a report. It returns the exit code: 1 if the command
+// failed on any device, 0 otherwise.
+func execFleet(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	file := fs.String("c", "./config.yml", "`path` to config.yml configuration file")
+	macs := fs.String("mac", "", "comma separated list of MAC addresses")
+	var filter provisioner.DeviceFilter
+	fs.StringVar(&filter.Hostname, "hostname", "", "hostname `pattern`")
+	fs.StringVar(&filter.Model, "model", "", "model `pattern`, e.g. \"NanoBeam*\"")
+	fs.StringVar(&filter.Platform, "platform", "", "platform `pattern`")
+	fs.StringVar(&filter.Firmware, "firmware", "", "firmware `pattern`")
+	wait := fs.Duration("wait", 5*time.Second, "time to wait for devices to be discovered")
+	concurrency := fs.Int("concurrency", 10, "number of devices to run the command on in parallel")
+	timeout := fs.Duration("timeout", 0, "per-device timeout (default exec.timeout from the config file)")
+	asJSON := fs.Bool("json", false, "print a JSON report")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s exec:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s exec [flags] command...\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Runs a command via SSH on all discovered devices matching the filter flags.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cmd := strings.Join(fs.Args(), " ")
+	if cmd == "" {
+		fs.Usage()
+		return 2
+	}
+	if *macs != "" {
+		filter.MacAddresses = strings.Split(*macs, ",")
+	}
+
+	config, errs := provisioner.LoadConfig(*file)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *file, e)
+		}
+		return 1
+	}
+	if *timeout > 0 {
+		config.Exec.Timeout = *timeout
+	}
+
+	discover, err := config.StartAutoDiscover(func(*discovery.Device) {})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer discover.Close()
+	time.Sleep(*wait)
+
+	devices := config.FilterDevices(&filter)
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "no matching devices found")
+		return 1
+	}
+
+	reports := provisioner.RunCommand(context.Background(), devices, cmd, *concurrency)
+	list := make([]*execReportJSON, len(reports))
+	failed := 0
+	for i, r := range reports {
+		list[i] = makeExecReportJSON(r)
+		if list[i].Error != "" || list[i].ExitCode != 0 {
+			failed++
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(list)
+	} else {
+		printExecReport(list)
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func makeExecReportJSON(r *provisioner.ExecReport) *execReportJSON {
+	j := &execReportJSON{
+		MacAddress: r.Device.MacAddress,
+		Hostname:   r.Device.Hostname,
+		Model:      r.Device.Model,
+		Firmware:   r.Device.Firmware,
+		IPAddress:  r.Device.IPAddress(),
+		ExitCode:   -1,
+	}
+	if res := r.Result; res != nil {
+		j.ExitCode = res.ExitCode
+		j.Duration = res.Duration.Seconds()
+		j.Stdout = string(res.Stdout)
+		j.Stderr = string(res.Stderr)
+	}
+	if r.Err != nil {
+		j.Error = r.Err.Error()
+	}
+	return j
+}
+
+// printExecReport prints the output of each device, followed by a
+// summary table.
+func printExecReport(list []*execReportJSON) {
+	for _, r := range list {
+		fmt.Printf("==> %s (%s, %s)\n", r.MacAddress, r.Hostname, r.Model)
+		if r.Stdout != "" {
+			fmt.Print(ensureNewline(r.Stdout))
+		}
+		if r.Stderr != "" {
+			fmt.Print(ensureNewline(r.Stderr))
+		}
+		if r.Error != "" {
+			fmt.Printf("error: %s\n", r.Error)
+		}
+		fmt.Println()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAC ADDRESS\tHOSTNAME\tMODEL\tFIRMWARE\tEXIT\tDURATION")
+	for _, r := range list {
+		exit := fmt.Sprint(r.ExitCode)
+		if r.Error != "" {
+			exit = "error"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.2fs\n", r.MacAddress, r.Hostname, r.Model, r.Firmware, exit, r.Duration)
+	}
+	tw.Flush()
+}
+
+func ensureNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
@@ -24,9 +24,19 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(check(os.Args[2:]))
+		case "exec":
+			os.Exit(execFleet(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, goldflags.Banner(appName))
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags]\n  %s check [-c config.yml]\n  %s exec [-c config.yml] [filter flags] command...\n\n", os.Args[0], os.Args[0], os.Args[0])
 		printExampleConfig()
 		flag.PrintDefaults()
 	}
@@ -62,9 +72,14 @@ func main() {
 	defer discover.Close()
 	go web.StartWeb(configuration)
 
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		configuration.Reload()
+	}
 }
 
 func logDevice(device *discovery.Device) {
@@ -30,6 +30,7 @@ type NotifyHandler func(*Device)
 
 type Discover struct {
 	NotifyHandler NotifyHandler
+	updateHandler NotifyHandler
 	connections   []*net.UDPConn
 	incoming      chan *Packet
 	stop          chan interface{}
@@ -213,10 +214,24 @@ func (d *Discover) handleIncoming() {
 		} else {
 			d.devices[dev.MacAddress] = dev
 		}
+		update := d.updateHandler
 		d.mutex.Unlock()
+
+		if update != nil {
+			update(dev.Clone())
+		}
 	}
 }
 
+// OnUpdate registers a handler, which receives a copy of a device each
+// time a discovery response arrives (in contrast to NotifyHandler, which
+// is only called for new devices).
+func (d *Discover) OnUpdate(handler NotifyHandler) {
+	d.mutex.Lock()
+	d.updateHandler = handler
+	d.mutex.Unlock()
+}
+
 // List all discovered devices so far. Will create duplicates of the
 // actual device list, so that it'll be safe to work with the result.
 func (d *Discover) List() (list []*Device) {
@@ -1,6 +1,9 @@
 package discovery
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Device descibes an UBNT device found on the local network
 type Device struct {
@@ -13,6 +16,7 @@ type Device struct {
 	UpSince      time.Time
 	Essid        string
 	WirelessMode string
+	SSHPort      uint16
 	LastSeenAt   time.Time
 	FirstSeenAt  time.Time
 }
@@ -28,7 +32,9 @@ func (d *Device) RecentlySeen(dur time.Duration) bool {
 func (d *Device) Merge(other *Device) {
 	d.Model = other.Model
 	d.Platform = other.Platform
-	d.MacAddress = other.MacAddress
+	if d.MacAddress == "" {
+		d.MacAddress = other.MacAddress // identifies the device, never changes
+	}
 	d.Hostname = other.Hostname
 	d.Firmware = other.Firmware
 	d.IPAddresses = make(map[string][]string)
@@ -38,6 +44,7 @@ func (d *Device) Merge(other *Device) {
 	d.UpSince = other.UpSince
 	d.Essid = other.Essid
 	d.WirelessMode = other.WirelessMode
+	d.SSHPort = other.SSHPort
 	d.LastSeenAt = other.LastSeenAt
 	if d.FirstSeenAt.After(other.FirstSeenAt) {
 		d.FirstSeenAt = other.FirstSeenAt
@@ -75,6 +82,10 @@ func (d *Device) String() string {
 		}
 	}
 
+	if d.SSHPort != 0 {
+		buf += fmt.Sprintf("\n  SSH port:     %d", d.SSHPort)
+	}
+
 	if d.Essid != "" {
 		buf += "\n  ESSID:        " + d.Essid
 	}
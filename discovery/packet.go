@@ -93,6 +93,10 @@ func (p *Packet) Device() *Device {
 				dur := -1 * int(v)
 				dev.UpSince = now.Add(time.Duration(dur) * time.Second)
 			}
+		case tagSshdPort:
+			if v, ok := t.value.(uint16); ok {
+				dev.SSHPort = v
+			}
 		case tagWmode:
 			if v, ok := t.value.(uint8); ok {
 				switch v {
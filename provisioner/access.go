@@ -0,0 +1,145 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Results of the last SSH connection attempt to a device.
+const (
+	AccessUnknown         = ""
+	AccessOK              = "auth OK"
+	AccessAuthFailed      = "auth failed"
+	AccessUnreachable     = "unreachable"
+	AccessHostKeyMismatch = "host key mismatch"
+)
+
+// accessState remembers the credential which worked last time, and the
+// outcome of the last connection attempt.
+type accessState struct {
+	status    string
+	credID    string // id of the working sshCredential
+	method    string // e.g. "password as ubnt"
+	checkedAt time.Time
+	err       error
+	mtx       sync.RWMutex
+}
+
+// Access returns the outcome of the last SSH connection attempt, the
+// auth method used (if successful), the time of the attempt and the last
+// error. status is AccessUnknown, if no attempt was made.
+func (d *Device) Access() (status, method string, checkedAt time.Time, err error) {
+	d.access.mtx.RLock()
+	defer d.access.mtx.RUnlock()
+	return d.access.status, d.access.method, d.access.checkedAt, d.access.err
+}
+
+func (d *Device) setAccess(status string, cred *sshCredential, err error) {
+	d.access.mtx.Lock()
+	changed := d.access.status != status
+	d.access.status, d.access.checkedAt, d.access.err = status, time.Now(), err
+	if cred != nil {
+		d.access.credID = cred.id
+		d.access.method = fmt.Sprintf("%s as %s", cred.typ, cred.user)
+	} else {
+		d.access.method = ""
+	}
+	d.access.mtx.Unlock()
+
+	if changed {
+		d.events.publish(&Event{Type: EventDeviceChanged, Device: d})
+	}
+}
+
+// orderedCredentials returns the credentials of the device, with the one
+// which worked last time in front.
+func (d *Device) orderedCredentials(cfg *deviceSettings) []sshCredential {
+	d.access.mtx.RLock()
+	id := d.access.credID
+	d.access.mtx.RUnlock()
+
+	list := make([]sshCredential, 0, len(cfg.credentials))
+	for _, cred := range cfg.credentials {
+		if cred.id == id {
+			list = append([]sshCredential{cred}, list...)
+		} else {
+			list = append(list, cred)
+		}
+	}
+	return list
+}
+
+// dialSSH tries the credentials of the device until one succeeds. Once
+// an address is found to be unreachable, it is not tried again. A host
+// key mismatch (see HostKeyError) aborts immediately.
+func (d *Device) dialSSH(cfg *deviceSettings) (*ssh.Client, error) {
+	if cfg.ipAddress == "" {
+		err := fmt.Errorf("device %s has no unique IP address", d.MacAddress)
+		d.setAccess(AccessUnreachable, nil, err)
+		return nil, err
+	}
+
+	var lastErr error
+	status := AccessAuthFailed
+	unreachable := make(map[string]bool)
+	tries := 0
+
+	for _, cred := range d.orderedCredentials(cfg) {
+		addr := net.JoinHostPort(cfg.ipAddress, strconv.Itoa(d.sshPortFor(cfg, cred)))
+		if unreachable[addr] {
+			continue
+		}
+		tries++
+
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			Timeout:         2 * time.Second,
+			User:            cred.user,
+			Auth:            []ssh.AuthMethod{cred.method},
+			HostKeyCallback: cfg.hostKeys.callback(d.MacAddress),
+		})
+		if err == nil {
+			d.log("(try %d) %s authentication as %s@%s succeeded", tries, cred.typ, cred.user, addr)
+			d.setAccess(AccessOK, &cred, nil)
+			return client, nil
+		}
+
+		d.log("(try %d) %s authentication as %s@%s failed with %v", tries, cred.typ, cred.user, addr, err)
+		lastErr = err
+		var keyErr *HostKeyError
+		if errors.As(err, &keyErr) {
+			status = AccessHostKeyMismatch
+			break
+		}
+		if _, ok := err.(net.Error); ok {
+			unreachable[addr] = true
+			if len(unreachable) == tries {
+				status = AccessUnreachable
+			}
+		} else {
+			status = AccessAuthFailed
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no SSH credentials configured")
+	}
+	d.setAccess(status, nil, lastErr)
+	return nil, lastErr
+}
+
+// CheckAccess connects to the device and reports the outcome (see
+// Access).
+func (d *Device) CheckAccess() (string, error) {
+	client, err := d.dialSSH(d.settings())
+	if client != nil {
+		client.Close()
+	}
+	status, _, _, _ := d.Access()
+	return status, err
+}
@@ -0,0 +1,95 @@
+package provisioner
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOrderedCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "a"}, {Password: "b"}, {Password: "c"}}, 0)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.credentials = creds
+
+	assert.Equal([]string{"ssh.0", "ssh.1", "ssh.2"}, credentialIDs(dev.orderedCredentials(dev.cfg)))
+
+	dev.setAccess(AccessOK, &creds[2], nil)
+	assert.Equal([]string{"ssh.2", "ssh.0", "ssh.1"}, credentialIDs(dev.orderedCredentials(dev.cfg)))
+
+	status, method, _, err := dev.Access()
+	assert.Equal(AccessOK, status)
+	assert.Equal("password as ubnt", method)
+	assert.NoError(err)
+}
+
+func TestCheckAccessUnreachable(t *testing.T) {
+	assert := assert.New(t)
+
+	// find a closed port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "a"}, {Password: "b"}}, port)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.ipAddress = "127.0.0.1"
+	dev.cfg.credentials = creds
+
+	status, err := dev.CheckAccess()
+	assert.Equal(AccessUnreachable, status)
+	assert.Error(err)
+	assert.Len(dev.Log(), 1) // second credential was skipped
+}
+
+func TestCheckAccessNoAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "a"}}, 0)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.credentials = creds
+
+	status, err := dev.CheckAccess()
+	assert.Equal(AccessUnreachable, status)
+	assert.EqualError(err, "device 00:11:22:aa:bb:cc has no unique IP address")
+	assert.Empty(dev.Log())
+}
+
+func TestCheckAccessHostKeyMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	port, conns, stop := testSSHServer(t)
+	defer stop()
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "a"}, {Password: "b"}}, port)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.ipAddress = "127.0.0.1"
+	dev.cfg.credentials = creds
+	dev.cfg.hostKeys = &hostKeyStore{
+		mode: HostKeysTOFU,
+		keys: map[string]ssh.PublicKey{"001122aabbcc": newTestHostKey(t)},
+	}
+
+	status, err := dev.CheckAccess()
+	assert.Equal(AccessHostKeyMismatch, status)
+	var keyErr *HostKeyError
+	assert.True(errors.As(err, &keyErr))
+	assert.Len(dev.Log(), 1) // second credential was skipped
+	assert.EqualValues(0, atomic.LoadInt32(conns))
+}
+
+func credentialIDs(list []sshCredential) (ids []string) {
+	for _, cred := range list {
+		ids = append(ids, cred.id)
+	}
+	return
+}
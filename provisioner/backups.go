@@ -0,0 +1,175 @@
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// backupTimeFormat is used to name backup files (in UTC).
+const backupTimeFormat = "20060102T150405Z"
+
+// remoteConfigPath is the location of the running configuration on
+// AirOS devices.
+const remoteConfigPath = "/tmp/system.cfg"
+
+// BackupNamePattern matches the names of backup files. Backups taken
+// within the same second get a sequence number ("<time>-1.cfg").
+var BackupNamePattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z(-[0-9]+)?\.cfg$`)
+
+// Backup describes a copy of a device's system.cfg, taken before it was
+// overwritten. Backups are stored as <backup_directory>/<mac>/<name>.
+type Backup struct {
+	Name      string
+	CreatedAt time.Time
+	Size      int64
+
+	seq int // distinguishes backups taken within the same second
+}
+
+// backupDir returns the directory containing the backups of the device.
+// It is empty, if no backup_directory is configured.
+func (d *Device) backupDir() string {
+	root := d.settings().backupDirectory
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, sanitizeMac(d.MacAddress))
+}
+
+// backupConfig downloads the current system.cfg of the device and stores
+// it in the backup directory. Without backup_directory, nothing happens.
+func (d *Device) backupConfig(c *ssh.Client) error {
+	dir := d.backupDir()
+	if dir == "" {
+		d.log("No backup_directory configured, skipping backup")
+		return nil
+	}
+
+	var content bytes.Buffer
+	if _, err := pssh.Download(c, remoteConfigPath, &content, nil); err != nil {
+		return fmt.Errorf("Could not read configuration: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	name, err := writeBackup(dir, time.Now(), content.Bytes())
+	if err != nil {
+		return err
+	}
+	d.log("Configuration backup saved as %s", name)
+	return nil
+}
+
+// writeBackup stores content in a new file in dir, named after the given
+// time. Existing backups are never overwritten; instead, a sequence number
+// is appended to the name.
+func writeBackup(dir string, now time.Time, content []byte) (string, error) {
+	base := now.UTC().Format(backupTimeFormat)
+	for seq := 0; ; seq++ {
+		name := base + ".cfg"
+		if seq > 0 {
+			name = fmt.Sprintf("%s-%d.cfg", base, seq)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(content)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return name, err
+	}
+}
+
+// Backups lists the configuration backups of the device, newest first.
+func (d *Device) Backups() ([]*Backup, error) {
+	dir := d.backupDir()
+	if dir == "" {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var list []*Backup
+	for _, fi := range files {
+		if fi.IsDir() || !BackupNamePattern.MatchString(fi.Name()) {
+			continue
+		}
+		name := fi.Name()
+		t, err := time.Parse(backupTimeFormat, name[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		b := &Backup{Name: name, CreatedAt: t, Size: fi.Size()}
+		if suffix := strings.TrimSuffix(name[len(backupTimeFormat):], ".cfg"); suffix != "" {
+			b.seq, _ = strconv.Atoi(suffix[1:])
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].seq > list[j].seq
+	})
+	return list, nil
+}
+
+// BackupPath returns the local path of a backup file. It fails, if the
+// name is invalid or the backup does not exist.
+func (d *Device) BackupPath(name string) (string, error) {
+	dir := d.backupDir()
+	if dir == "" {
+		return "", fmt.Errorf("backups are disabled")
+	}
+	if !BackupNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Restore enqueues a job, which writes the given backup to the device
+// and reboots it. The replaced configuration is backed up as well.
+func (d *Device) Restore(name string) (*Job, error) {
+	path, err := d.BackupPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.enqueue("restore", "restoring", func() error {
+		return d.withSSHClient(func(c *ssh.Client) error {
+			d.log("Restoring configuration backup %s", name)
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return d.writeConfig(c, content, path)
+		})
+	}), nil
+}
@@ -0,0 +1,67 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackups(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:AA:BB:CC"})
+	dev.cfg.backupDirectory = dir
+
+	list, err := dev.Backups()
+	assert.NoError(err)
+	assert.Empty(list)
+
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "001122aabbcc"), 0755))
+	for _, name := range []string{"20170601T120000Z.cfg", "20170701T080000Z-10.cfg", "20170701T080000Z-2.cfg", "20170701T080000Z.cfg", "notes.txt"} {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, "001122aabbcc", name), []byte("x=y\n"), 0644))
+	}
+
+	list, err = dev.Backups()
+	assert.NoError(err)
+	if assert.Len(list, 4) {
+		assert.Equal("20170701T080000Z-10.cfg", list[0].Name)
+		assert.Equal("20170701T080000Z-2.cfg", list[1].Name)
+		assert.Equal("20170701T080000Z.cfg", list[2].Name)
+		assert.Equal(2017, list[0].CreatedAt.Year())
+		assert.EqualValues(4, list[0].Size)
+	}
+
+	path, err := dev.BackupPath("20170601T120000Z.cfg")
+	assert.NoError(err)
+	assert.Equal(filepath.Join(dir, "001122aabbcc", "20170601T120000Z.cfg"), path)
+
+	_, err = dev.BackupPath("../../etc/passwd")
+	assert.EqualError(err, `invalid backup name "../../etc/passwd"`)
+
+	_, err = dev.BackupPath("20170801T120000Z.cfg")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestWriteBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, expected := range []string{"20170601T120000Z.cfg", "20170601T120000Z-1.cfg", "20170601T120000Z-2.cfg"} {
+		name, err := writeBackup(dir, now, []byte{byte('a' + i)})
+		assert.NoError(err)
+		assert.Equal(expected, name)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "20170601T120000Z.cfg"))
+	assert.NoError(err)
+	assert.Equal("a", string(content))
+}
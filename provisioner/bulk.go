@@ -0,0 +1,373 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bulkHistorySize limits the number of finished bulk operations we
+// remember.
+const bulkHistorySize = 50
+
+// BulkOptions controls the execution of a bulk operation. Up to
+// Concurrency jobs run at the same time; whenever one has finished, its
+// worker pauses for BatchDelay before starting the next one. Once
+// MaxFailures jobs have failed, no further jobs are started (0 disables
+// this check, nil means the configured default).
+type BulkOptions struct {
+	Concurrency int           `yaml:"concurrency"`
+	MaxFailures *int          `yaml:"max_failures"`
+	BatchDelay  time.Duration `yaml:"batch_delay"`
+}
+
+// maxFailures returns the failure threshold (0 if disabled).
+func (o *BulkOptions) maxFailures() int {
+	if o.MaxFailures == nil {
+		return 0
+	}
+	return *o.MaxFailures
+}
+
+// BulkResult describes the outcome for a single device of a bulk
+// operation. Err is set, if no job could be created for the device.
+type BulkResult struct {
+	MacAddress string
+	Job        *Job
+	Err        error
+	Skipped    bool
+}
+
+// BulkProgress summarizes the results of a bulk operation.
+type BulkProgress struct {
+	Total     int
+	Pending   int
+	Running   int
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// Bulk tracks an operation (upgrade, provision, reboot, ...) on a number
+// of devices.
+type Bulk struct {
+	ID        uint64
+	Action    string
+	Options   BulkOptions
+	CreatedAt time.Time
+
+	state      JobState
+	finishedAt time.Time
+	results    []*BulkResult
+	cancel     chan struct{}
+	done       chan struct{}
+	events     *eventBus
+	mtx        sync.RWMutex
+}
+
+// State returns the current state.
+func (b *Bulk) State() JobState {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return b.state
+}
+
+// FinishedAt returns the time the operation has finished (or the zero
+// time, if it is still running).
+func (b *Bulk) FinishedAt() time.Time {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return b.finishedAt
+}
+
+// Results returns a copy of the per-device results.
+func (b *Bulk) Results() []BulkResult {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	list := make([]BulkResult, len(b.results))
+	for i, r := range b.results {
+		list[i] = *r
+	}
+	return list
+}
+
+// Progress aggregates the per-device results.
+func (b *Bulk) Progress() (p BulkProgress) {
+	for _, r := range b.Results() {
+		p.Total++
+		switch {
+		case r.Skipped:
+			p.Skipped++
+		case r.Err != nil:
+			p.Failed++
+		case r.Job == nil:
+			p.Pending++
+		default:
+			switch r.Job.State() {
+			case JobQueued, JobRunning:
+				p.Running++
+			case JobSucceeded:
+				p.Succeeded++
+			default:
+				p.Failed++
+			}
+		}
+	}
+	return
+}
+
+// Wait blocks until the operation has finished.
+func (b *Bulk) Wait() {
+	<-b.done
+}
+
+// Cancel stops the operation: no further batches are started and queued
+// jobs are cancelled. Running jobs will finish.
+func (b *Bulk) Cancel() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state != JobQueued && b.state != JobRunning {
+		return fmt.Errorf("bulk operation %d is %s and cannot be cancelled", b.ID, b.state)
+	}
+	select {
+	case <-b.cancel:
+	default:
+		close(b.cancel)
+	}
+	for _, r := range b.results {
+		if r.Job != nil {
+			r.Job.Cancel()
+		}
+	}
+	return nil
+}
+
+func (b *Bulk) cancelled() bool {
+	select {
+	case <-b.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopped is true, if no further jobs should be started.
+func (b *Bulk) stopped() bool {
+	if b.cancelled() {
+		return true
+	}
+	max := b.Options.maxFailures()
+	return max > 0 && b.Progress().Failed >= max
+}
+
+func (b *Bulk) publish() {
+	b.events.publish(&Event{Type: EventBulk, Bulk: b})
+}
+
+// run processes all devices with a pool of Options.Concurrency workers.
+// start must enqueue a job for the given device.
+func (b *Bulk) run(devices []*Device, start func(*Device) (*Job, error)) {
+	b.mtx.Lock()
+	b.state = JobRunning
+	b.mtx.Unlock()
+	b.publish()
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < b.Options.Concurrency && w < len(devices); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			busy := false
+			for i := range next {
+				if busy {
+					b.pause()
+				}
+				if !b.stopped() {
+					b.runOne(i, devices[i], start)
+					busy = true
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range devices {
+		if b.stopped() {
+			break
+		}
+		select {
+		case next <- i:
+		case <-b.cancel:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	b.mtx.Lock()
+	for _, r := range b.results {
+		if r.Job == nil && r.Err == nil {
+			r.Skipped = true
+		}
+	}
+	b.mtx.Unlock()
+
+	p := b.Progress()
+	b.mtx.Lock()
+	switch {
+	case b.cancelled():
+		b.state = JobCancelled
+	case p.Failed > 0 || p.Skipped > 0:
+		b.state = JobFailed
+	default:
+		b.state = JobSucceeded
+	}
+	b.finishedAt = time.Now()
+	close(b.done)
+	b.mtx.Unlock()
+	b.publish()
+}
+
+// runOne starts the job for the i-th device and waits for it.
+func (b *Bulk) runOne(i int, dev *Device, start func(*Device) (*Job, error)) {
+	job, err := start(dev)
+
+	b.mtx.Lock()
+	b.results[i].Job, b.results[i].Err = job, err
+	b.mtx.Unlock()
+	b.publish()
+
+	if err == nil {
+		job.Wait()
+		b.publish()
+	}
+}
+
+// pause waits for Options.BatchDelay (or until the operation is
+// cancelled).
+func (b *Bulk) pause() {
+	if b.Options.BatchDelay > 0 {
+		select {
+		case <-b.cancel:
+		case <-time.After(b.Options.BatchDelay):
+		}
+	}
+}
+
+type bulkList struct {
+	list   []*Bulk // ordered by ID
+	lastID uint64
+	sync.RWMutex
+}
+
+// expire removes the oldest finished operations, if we remember too
+// many. The caller must hold the lock.
+func (l *bulkList) expire() {
+	excess := len(l.list) - bulkHistorySize
+	if excess <= 0 {
+		return
+	}
+
+	kept := l.list[:0]
+	for _, b := range l.list {
+		if excess > 0 && !b.FinishedAt().IsZero() {
+			excess--
+			continue
+		}
+		kept = append(kept, b)
+	}
+	for i := len(kept); i < len(l.list); i++ {
+		l.list[i] = nil
+	}
+	l.list = kept
+}
+
+func (l *bulkList) find(id uint64) *Bulk {
+	l.RLock()
+	defer l.RUnlock()
+
+	i := sort.Search(len(l.list), func(i int) bool { return l.list[i].ID >= id })
+	if i < len(l.list) && l.list[i].ID == id {
+		return l.list[i]
+	}
+	return nil
+}
+
+// bulkActions maps action names to device methods.
+var bulkActions = map[string]func(*Device) (*Job, error){
+	"upgrade":   (*Device).Upgrade,
+	"provision": (*Device).Provision,
+	"reboot":    (*Device).Reboot,
+}
+
+// StartBulk executes an action ("upgrade", "provision" or "reboot") on
+// all given devices in background. Zero values in opts are replaced by
+// the defaults from the config file.
+func (c *Configuration) StartBulk(action string, devices []*Device, opts BulkOptions) (*Bulk, error) {
+	start, ok := bulkActions[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown bulk action %q", action)
+	}
+	return c.startBulk(action, devices, opts, start)
+}
+
+func (c *Configuration) startBulk(action string, devices []*Device, opts BulkOptions, start func(*Device) (*Job, error)) (*Bulk, error) {
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices selected")
+	}
+	defaults := c.settings().Bulk
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaults.Concurrency
+	}
+	if opts.MaxFailures == nil {
+		opts.MaxFailures = defaults.MaxFailures
+	}
+	if opts.BatchDelay <= 0 {
+		opts.BatchDelay = defaults.BatchDelay
+	}
+
+	b := &Bulk{
+		Action:    action,
+		Options:   opts,
+		CreatedAt: time.Now(),
+		state:     JobQueued,
+		cancel:    make(chan struct{}),
+		done:      make(chan struct{}),
+		events:    c.events,
+	}
+	for _, dev := range devices {
+		b.results = append(b.results, &BulkResult{MacAddress: dev.MacAddress})
+	}
+
+	c.bulks.Lock()
+	c.bulks.lastID++
+	b.ID = c.bulks.lastID
+	c.bulks.list = append(c.bulks.list, b)
+	c.bulks.expire()
+	c.bulks.Unlock()
+
+	go b.run(devices, start)
+	return b, nil
+}
+
+// GetBulks returns all bulk operations, newest first.
+func (c *Configuration) GetBulks() (list []*Bulk) {
+	c.bulks.RLock()
+	defer c.bulks.RUnlock()
+
+	list = make([]*Bulk, 0, len(c.bulks.list))
+	for i := len(c.bulks.list) - 1; i >= 0; i-- {
+		list = append(list, c.bulks.list[i])
+	}
+	return
+}
+
+// FindBulk searches the list of bulk operations and returns a pointer to
+// it (or nil, if we can't find it).
+func (c *Configuration) FindBulk(id uint64) *Bulk {
+	return c.bulks.find(id)
+}
@@ -0,0 +1,131 @@
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBulk(t *testing.T, opts BulkOptions, n int, fail map[int]bool) *Bulk {
+	c := &Configuration{bulks: &bulkList{}}
+	jobs := &jobList{}
+
+	var devices []*Device
+	index := make(map[*Device]int)
+	for i := 0; i < n; i++ {
+		dev := newDevice(&discovery.Device{MacAddress: fmt.Sprintf("00:00:00:00:00:%02x", i)})
+		devices = append(devices, dev)
+		index[dev] = i
+	}
+
+	b, err := c.startBulk("test", devices, opts, func(dev *Device) (*Job, error) {
+		i := index[dev]
+		if i == 0 && fail[-1] {
+			return nil, errors.New("precondition failed")
+		}
+		job := jobs.create("test", dev.MacAddress)
+		job.start()
+		go func() {
+			if fail[i] {
+				job.finish(errors.New("boom"))
+			} else {
+				job.finish(nil)
+			}
+		}()
+		return job, nil
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	b.Wait()
+	return b
+}
+
+func TestBulkSucceeded(t *testing.T) {
+	b := testBulk(t, BulkOptions{Concurrency: 2}, 5, nil)
+	assert.Equal(t, JobSucceeded, b.State())
+	assert.Equal(t, BulkProgress{Total: 5, Succeeded: 5}, b.Progress())
+}
+
+func TestBulkMaxFailures(t *testing.T) {
+	one, zero := 1, 0
+
+	b := testBulk(t, BulkOptions{Concurrency: 1, MaxFailures: &one}, 5, map[int]bool{1: true})
+	assert.Equal(t, JobFailed, b.State())
+	assert.Equal(t, BulkProgress{Total: 5, Succeeded: 1, Failed: 1, Skipped: 3}, b.Progress())
+
+	// 0 disables the threshold
+	b = testBulk(t, BulkOptions{Concurrency: 1, MaxFailures: &zero}, 5, map[int]bool{1: true})
+	assert.Equal(t, JobFailed, b.State())
+	assert.Equal(t, BulkProgress{Total: 5, Succeeded: 4, Failed: 1}, b.Progress())
+}
+
+func TestBulkWorkerPool(t *testing.T) {
+	c := &Configuration{bulks: &bulkList{}}
+	jobs := &jobList{}
+
+	var devices []*Device
+	for i := 0; i < 4; i++ {
+		devices = append(devices, newDevice(&discovery.Device{MacAddress: fmt.Sprintf("00:00:00:00:00:%02x", i)}))
+	}
+
+	// the first job blocks until all others have finished, which would
+	// never happen if the devices were processed in batches
+	slow := make(chan struct{})
+	var others sync.WaitGroup
+	others.Add(len(devices) - 1)
+	go func() {
+		others.Wait()
+		close(slow)
+	}()
+
+	b, err := c.startBulk("test", devices, BulkOptions{Concurrency: 2}, func(dev *Device) (*Job, error) {
+		job := jobs.create("test", dev.MacAddress)
+		job.start()
+		go func() {
+			if dev == devices[0] {
+				<-slow
+			} else {
+				defer others.Done()
+			}
+			job.finish(nil)
+		}()
+		return job, nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	select {
+	case <-b.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bulk operation did not finish")
+	}
+	assert.Equal(t, BulkProgress{Total: 4, Succeeded: 4}, b.Progress())
+}
+
+func TestBulkExpire(t *testing.T) {
+	l := &bulkList{}
+	for i := 0; i < bulkHistorySize+5; i++ {
+		b := &Bulk{ID: uint64(i + 1)}
+		if i%2 == 0 {
+			b.finishedAt = time.Now()
+		}
+		l.list = append(l.list, b)
+	}
+	l.expire()
+	assert.Len(t, l.list, bulkHistorySize)
+	assert.True(t, l.list[0].FinishedAt().IsZero()) // oldest finished ones removed first
+}
+
+func TestBulkPrecondition(t *testing.T) {
+	b := testBulk(t, BulkOptions{Concurrency: 3}, 3, map[int]bool{-1: true})
+	assert.Equal(t, JobFailed, b.State())
+	assert.Equal(t, BulkProgress{Total: 3, Succeeded: 2, Failed: 1}, b.Progress())
+}
@@ -0,0 +1,182 @@
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+)
+
+// requiredConfigKeys must be present in every system.cfg. Without an
+// admin user, the device would be inaccessible after provisioning.
+var requiredConfigKeys = []string{"users.status", "users.1.name", "users.1.password"}
+
+// configFilePattern matches device specific config file names.
+var configFilePattern = regexp.MustCompile(`^[0-9a-f]{12}\.cfg$`)
+
+// CheckIssue is a problem found by CheckConfig. Warnings don't prevent
+// the provisioner from working.
+type CheckIssue struct {
+	File    string
+	Message string
+	Warning bool
+}
+
+func (i CheckIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.File == "" {
+		return fmt.Sprintf("%s: %s", level, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, i.File, i.Message)
+}
+
+// CheckConfig validates a config file and the assets it refers to
+// (system configs, templates, firmware images and SSH keys), without
+// contacting any device.
+func CheckConfig(fileName string) (issues []CheckIssue) {
+	fail := func(file, format string, v ...interface{}) {
+		issues = append(issues, CheckIssue{File: file, Message: fmt.Sprintf(format, v...)})
+	}
+	warn := func(file, format string, v ...interface{}) {
+		issues = append(issues, CheckIssue{File: file, Message: fmt.Sprintf(format, v...), Warning: true})
+	}
+
+	c, errs := LoadConfig(fileName)
+	for _, err := range errs {
+		fail(fileName, "%v", err)
+	}
+	if c == nil || c.firmwares == nil {
+		return // config file unreadable
+	}
+
+	// system configs
+	filepath.Walk(c.ConfigDirectory, func(path string, fi os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(c.ConfigDirectory, path)
+		if err != nil {
+			fail(rel, "%v", err)
+			return nil
+		}
+		if fi.IsDir() {
+			if path != c.ConfigDirectory && !isMatchDirectory(rel) {
+				warn(rel, "unexpected directory, ignored")
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".cfg") {
+			warn(rel, "not a .cfg file, ignored")
+			return nil
+		}
+		if filepath.Dir(rel) == "." && rel != "default.cfg" && !configFilePattern.MatchString(rel) {
+			fail(rel, "file name is not a MAC address (expected aabbccddeeff.cfg)")
+		}
+		checkSystemConfig(path, rel, fail)
+		return nil
+	})
+
+	// templates
+	if c.templates != nil {
+		checkTemplates(c.templates, fail)
+	}
+
+	// firmware images
+	referenced := make(map[string]bool)
+	for image := range c.SafeUpgradePaths {
+		referenced[image] = true
+		if f := c.firmwares.lookup(c.FirmwareDirectory, image); os.IsNotExist(f.Err) {
+			fail(image, "firmware image not found in firmware_directory")
+		} else if f.Err != nil {
+			fail(image, "%v", f.Err)
+		}
+	}
+	for _, name := range c.FirmwareImages() {
+		if name == firmwareManifest || strings.HasSuffix(name, firmwareSidecarExt) {
+			continue
+		}
+		if !referenced[name] {
+			warn(name, "firmware image not referenced in safe_upgrade_paths")
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool { return !issues[i].Warning && issues[j].Warning })
+	return
+}
+
+// isMatchDirectory checks whether dir is a subdirectory used by the
+// config_matching rules.
+func isMatchDirectory(dir string) bool {
+	switch dir {
+	case MatchHostname, MatchModel, MatchPlatform:
+		return true
+	}
+	return false
+}
+
+func checkSystemConfig(path, name string, fail func(file, format string, v ...interface{})) {
+	cfg, err := syscfg.Open(path)
+	if err != nil {
+		fail(name, "%v", err)
+		return
+	}
+	if len(cfg.Keys()) == 0 {
+		fail(name, "no configuration keys found")
+		return
+	}
+	for _, key := range requiredConfigKeys {
+		if _, ok := cfg.Get(key); !ok {
+			fail(name, "missing key %s", key)
+		}
+	}
+}
+
+// checkTemplates parses all templates and renders them for each device
+// listed in the variables file. Values usually obtained by discovery
+// (hostname, model, IP address, etc.) are left empty.
+func checkTemplates(t *configTemplates, fail func(file, format string, v ...interface{})) {
+	macs := make([]string, 0, len(t.vars.Devices))
+	for mac := range t.vars.Devices {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+
+	names, _ := filepath.Glob(filepath.Join(t.dir, "*"+templateExt))
+	for _, path := range names {
+		name := strings.TrimSuffix(filepath.Base(path), templateExt)
+		tpl, err := t.parse(name)
+		if err != nil {
+			fail(path, "%v", err)
+			continue
+		}
+
+		for _, mac := range macs {
+			vars := t.vars.lookup(mac, "")
+			if vars["template"] != name {
+				continue
+			}
+			data := &TemplateData{MacAddress: formatMac(mac), MacPlain: mac, Vars: vars}
+			if _, err := execute(tpl, data); err != nil {
+				fail(path, "device %s: %v", data.MacAddress, err)
+			}
+		}
+	}
+}
+
+// formatMac converts a sanitized MAC address ("001122aabbcc") into the
+// colon separated form.
+func formatMac(plain string) string {
+	if len(plain) != 12 {
+		return plain
+	}
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = plain[2*i : 2*i+2]
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,71 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("config.yml", `
+config_directory: ./configs
+firmware_directory: ./firmwares
+safe_upgrade_paths:
+  "XC.v8.1.4.bin": ["XC.qca955x.v7.2.4"]
+interfaces: [eth0]
+web: {port: 8080}
+templates:
+  directory: ./templates
+  variables: ./templates/vars.yml
+ssh:
+- type: keyfile
+  path: ./missing_key
+`)
+	write("configs/001122aabbcc.cfg", "users.status=enabled\nusers.1.name=ubnt\nusers.1.password=x\n")
+	write("configs/model/LiteBeam.cfg", "users.status=enabled\n")
+	write("configs/ap-1.cfg", "users.status=enabled\nusers.1.name=ubnt\nusers.1.password=x\n")
+	write("firmwares/old.bin", "")
+	write("templates/ap.cfg.tmpl", "system.hostname={{.Vars.hostname}}\n# {{.MacPlain}}\n")
+	write("templates/broken.cfg.tmpl", "{{.Vars.x\n")
+	write("templates/vars.yml", `
+devices:
+  "00:11:22:AA:BB:01": {template: ap, hostname: ap-1}
+  "00:11:22:AA:BB:02": {template: ap}
+`)
+
+	var list, tplIssues []string
+	for _, issue := range CheckConfig(filepath.Join(dir, "config.yml")) {
+		if strings.HasPrefix(issue.File, filepath.Join(dir, "templates")) {
+			tplIssues = append(tplIssues, issue.String())
+		} else {
+			list = append(list, issue.String())
+		}
+	}
+	assert.Equal([]string{
+		"error: " + filepath.Join(dir, "config.yml") + ": ssh.0: keyfile: open ./missing_key: no such file or directory",
+		"error: ap-1.cfg: file name is not a MAC address (expected aabbccddeeff.cfg)",
+		"error: model/LiteBeam.cfg: missing key users.1.name",
+		"error: model/LiteBeam.cfg: missing key users.1.password",
+		"error: XC.v8.1.4.bin: firmware image not found in firmware_directory",
+		"warning: old.bin: firmware image not referenced in safe_upgrade_paths",
+	}, list)
+
+	if assert.Len(tplIssues, 2) {
+		assert.Contains(tplIssues[0], "ap.cfg.tmpl: device 00:11:22:aa:bb:02: ")
+		assert.Contains(tplIssues[0], `map has no entry for key "hostname"`)
+		assert.Contains(tplIssues[1], "broken.cfg.tmpl: template: broken.cfg.tmpl:")
+	}
+}
@@ -0,0 +1,35 @@
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConfigDiff fetches the running configuration of the device and
+// compares it with the one which would be written by Provision().
+func (d *Device) ConfigDiff() (*syscfg.Diff, error) {
+	if !d.HasConfig() {
+		return nil, fmt.Errorf("no configuration for device %s available", d.MacAddress)
+	}
+	content, err := d.DesiredConfig()
+	if err != nil {
+		return nil, err
+	}
+	desired, err := syscfg.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var running *syscfg.Config
+	err = d.withSSHClient(func(c *ssh.Client) (err error) {
+		running, err = d.readConfig(c)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return syscfg.Compare(running, desired), nil
+}
@@ -0,0 +1,77 @@
+package provisioner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/digineo/goldflags"
+)
+
+// Rules for finding the system config of a device, see ConfigMatching.
+const (
+	MatchMac      = "mac"      // <config_directory>/aabbccddeeff.cfg
+	MatchTemplate = "template" // template assigned via templates.variables
+	MatchHostname = "hostname" // <config_directory>/hostname/<hostname>.cfg
+	MatchModel    = "model"    // <config_directory>/model/<model>.cfg
+	MatchPlatform = "platform" // <config_directory>/platform/<platform>.cfg
+	MatchDefault  = "default"  // <config_directory>/default.cfg
+)
+
+// defaultConfigMatching is used, when config_matching is empty.
+var defaultConfigMatching = []string{MatchMac, MatchTemplate, MatchHostname, MatchModel, MatchPlatform, MatchDefault}
+
+// checkConfigMatching validates the list of rules.
+func checkConfigMatching(rules []string) (errs []error) {
+	for _, rule := range rules {
+		switch rule {
+		case MatchMac, MatchTemplate, MatchHostname, MatchModel, MatchPlatform, MatchDefault:
+		default:
+			errs = append(errs, fmt.Errorf("unknown config_matching rule %q", rule))
+		}
+	}
+	return
+}
+
+// configFileName makes a discovery value usable as file name.
+func configFileName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return ""
+	}
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(name) + ".cfg"
+}
+
+// matchConfig applies the config_matching rules in order and returns
+// the first matching rule, together with a config path or template name.
+func (c *Configuration) matchConfig(dev *Device) (rule, path, template string) {
+	for _, rule = range c.ConfigMatching {
+		var name string
+		switch rule {
+		case MatchMac:
+			name = sanitizeMac(dev.MacAddress) + ".cfg"
+		case MatchTemplate:
+			if template = c.templates.templateFor(dev.MacAddress, dev.Model); template != "" {
+				return
+			}
+			continue
+		case MatchHostname, MatchModel, MatchPlatform:
+			value := map[string]string{
+				MatchHostname: dev.Hostname,
+				MatchModel:    dev.Model,
+				MatchPlatform: dev.Platform,
+			}[rule]
+			if name = configFileName(value); name == "" {
+				continue
+			}
+			name = filepath.Join(rule, name)
+		case MatchDefault:
+			name = "default.cfg"
+		}
+
+		if p := filepath.Join(c.ConfigDirectory, name); goldflags.PathExist(p) {
+			return rule, p, ""
+		}
+	}
+	return "", "", ""
+}
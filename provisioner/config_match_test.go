@@ -0,0 +1,56 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"001122aabbcc.cfg", "hostname/ap-1.cfg", "model/LiteBeam 5AC.cfg", "default.cfg"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(path, nil, 0644))
+	}
+
+	c := &Configuration{Settings: Settings{ConfigDirectory: dir, ConfigMatching: defaultConfigMatching}}
+	match := func(mac, hostname, model string) (string, string) {
+		dev := newDevice(&discovery.Device{MacAddress: mac, Hostname: hostname, Model: model})
+		rule, path, _ := c.matchConfig(dev)
+		if path != "" {
+			path, _ = filepath.Rel(dir, path)
+		}
+		return rule, path
+	}
+
+	rule, path := match("00:11:22:aa:bb:cc", "ap-1", "LiteBeam 5AC")
+	assert.Equal(MatchMac, rule)
+	assert.Equal("001122aabbcc.cfg", path)
+
+	rule, path = match("00:11:22:aa:bb:cd", "ap-1", "LiteBeam 5AC")
+	assert.Equal(MatchHostname, rule)
+	assert.Equal("hostname/ap-1.cfg", path)
+
+	rule, path = match("00:11:22:aa:bb:cd", "ap-2", "LiteBeam 5AC")
+	assert.Equal(MatchModel, rule)
+	assert.Equal("model/LiteBeam 5AC.cfg", path)
+
+	rule, path = match("00:11:22:aa:bb:cd", "../ap-1", "")
+	assert.Equal(MatchDefault, rule)
+	assert.Equal("default.cfg", path)
+
+	c.ConfigMatching = []string{MatchMac, MatchPlatform}
+	rule, path = match("00:11:22:aa:bb:cd", "ap-1", "LiteBeam 5AC")
+	assert.Equal("", rule)
+	assert.Equal("", path)
+
+	assert.Len(checkConfigMatching([]string{"mac", "serial"}), 1)
+}
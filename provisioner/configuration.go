@@ -6,18 +6,19 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/digineo/goldflags"
-	"github.com/digineo/ubnt-tools/discovery"
-	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
-	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
 // ExampleYAML provides a complete set of config options in YAML format
 // and can be used for documentation purposes.
 const ExampleYAML = `	---
-	# Notes on path values (config_directory, firmware_directory, web.templates):
+	# Notes on path values (config_directory, firmware_directory,
+	# backup_directory, templates.directory, templates.variables,
+	# host_keys.file, web.templates):
 	#
 	# - relative paths (i.e. those starting with "./") will be resolved relative
 	#   to the directory of this config file
@@ -29,15 +30,66 @@ const ExampleYAML = `	---
 	# device (i.e. lowercase, without seperator).
 	config_directory: /tmp/ubnt-config/configs
 
-	# Where do we find the firmware images?
+	# The system.cfg of a device is selected by the first matching rule:
+	#
+	# - mac:      <config_directory>/aabbccddeeff.cfg
+	# - template: template assigned in templates.variables (see below)
+	# - hostname: <config_directory>/hostname/<hostname>.cfg
+	# - model:    <config_directory>/model/<model>.cfg (e.g. "model/LiteBeam 5AC Gen2.cfg")
+	# - platform: <config_directory>/platform/<platform>.cfg
+	# - default:  <config_directory>/default.cfg
+	#
+	# Remove rules to disable them. This is the default order:
+	config_matching: [mac, template, hostname, model, platform, default]
+
+	# Where do we find the firmware images? Each image must have a SHA256
+	# checksum, either listed in a "SHA256SUMS" file (as created by
+	# "sha256sum *.bin > SHA256SUMS") or in a sidecar file named like the
+	# image plus ".sha256". Images without (matching) checksum are refused.
 	firmware_directory: /tmp/ubnt-config/firmwares
 
+	# Before a configuration is written to a device, its current system.cfg
+	# is saved as "<backup_directory>/aabbccddeeff/<timestamp>.cfg". Backups
+	# can be downloaded and restored via the web interface. Leave empty to
+	# disable backups.
+	backup_directory: /tmp/ubnt-config/backups
+
+	# Devices without a file in the config_directory may get their system.cfg
+	# rendered from a Go template ("<name>.cfg.tmpl" in templates.directory).
+	# The variables file (YAML or CSV) assigns templates and variables to
+	# devices:
+	#
+	#   defaults: {template: ap, ntp: 10.0.0.1}
+	#   models:   {"LiteBeam 5AC Gen2": {template: litebeam}}
+	#   groups:   {backbone: {template: ptp, channel: "5500"}}
+	#   devices:  {"00:11:22:aa:bb:cc": {group: backbone, hostname: bb-1}}
+	#
+	# Later sections override earlier ones. In CSV files, the header row
+	# names the variables and a "mac" column is required (use "default" and
+	# "group:<name>" as MAC to set defaults and group variables). Templates
+	# can access {{.MacAddress}}, {{.MacPlain}}, {{.Hostname}}, {{.Model}},
+	# {{.Platform}}, {{.Firmware}}, {{.IPAddress}} and {{.Vars.<name>}}.
+	templates:
+	  directory: /tmp/ubnt-config/templates
+	  variables: /tmp/ubnt-config/variables.yml
+
 	# This mapping describes safe upgrade paths. As key use the basename of the
 	# firmware image (located in the firmware_directory) and as values provide
 	# a list of firmware version identifiers found in the wild.
+	#
+	# The paths form a graph: a device is upgraded step by step to the newest
+	# firmware reachable from its current version. In this example, devices
+	# running v7.2.1 are upgraded to v7.2.4 first, and then to v8.1.4.
 	safe_upgrade_paths:
 	  "XC.v7.2.4.31259.160714.1715.bin":
 	    - "XC.qca955x.v7.2.1.30741.160412.1342"
+	  "XC.v8.1.4.34481.170608.1728.bin":
+	    - "XC.qca955x.v7.2.4.31259.160714.1715"
+
+	# After a firmware upgrade, we wait this long for the device to be
+	# rediscovered with the new firmware version. Otherwise an alert is
+	# raised.
+	upgrade_timeout: 10m
 
 	# This must be a list of interface names with broadcast and multicast
 	# capabilities.
@@ -45,15 +97,87 @@ const ExampleYAML = `	---
 	- eth0
 
 	# When accessing the devices via SSH, the authentication methods declared
-	# here are tried in order. This sample lists all available types:
+	# here are tried in order. This sample lists all available types. The
+	# user defaults to "ubnt".
 	ssh:
-	- type: keyfile
-	  path: ~/.ssh/id_rsa
+	- type: keyfile        # any format (OpenSSH, PKCS#1/#8), an existing
+	  path: ~/.ssh/id_rsa  # id_rsa-cert.pub is used as certificate
 	  password: foobar     # required if keyfile is password protected
 	- type: password
+	  user: admin
 	  password: super-secret
 	- type: ssh-agent      # try ssh-agent (needs SSH_AUTH_SOCK env var)
 
+	# The SSH port is taken from the discovery information. This port is used,
+	# when a device doesn't announce it.
+	ssh_port: 22
+
+	# SSH connections are reused for subsequent operations on the same device.
+	# Idle connections are closed after idle_timeout (default 1m) and probed
+	# every keepalive interval (default 15s). Dead connections are replaced
+	# transparently.
+	ssh_pool:
+	  disabled: false
+	  idle_timeout: 1m
+	  keepalive: 15s
+
+	# Devices may need different credentials. The first set matching a device
+	# (by mac_addresses, hostname, model, platform, firmware and/or group as
+	# assigned in templates.variables) replaces the list above. The port
+	# overrides the discovered one.
+	ssh_credentials:
+	- model: "LiteBeam*"
+	  port: 2222
+	  methods:
+	  - type: password
+	    user: fieldadmin
+	    password: other-secret
+
+	# SSH host keys are remembered per MAC address (IP addresses collide,
+	# since all devices share the same factory default). Modes:
+	#
+	# - tofu:   trust unknown keys on first use and remember them (default)
+	# - strict: only connect to devices with a known key
+	# - off:    don't verify host keys at all
+	#
+	# Connections to devices with a changed key (e.g. after a factory reset)
+	# fail, until the old key is removed via the web interface or API.
+	host_keys:
+	  mode: tofu
+	  file: ./known_hosts
+
+	# Defaults for bulk operations (POST /api/bulk/{action}), which may be
+	# overridden per request. Up to concurrency jobs run in parallel; after a
+	# job has finished, the next device is started after batch_delay. After
+	# max_failures failed jobs, no further jobs are started (0 disables this
+	# check).
+	bulk:
+	  concurrency: 5
+	  max_failures: 1
+	  batch_delay: 30s
+
+	# Reload this file automatically, when it is modified. Sending SIGHUP to
+	# the provisioner or POST /api/config/reload do the same. Changes to
+	# interfaces and web need a restart.
+	reload_on_change: false
+
+	# Periodically compare the running system.cfg of all reachable devices
+	# with the desired configuration. Devices which differ get the status
+	# "drift". An interval of 0 (the default) disables these checks.
+	drift:
+	  interval: 1h
+	  concurrency: 4
+
+	# Commands operators may run on devices via the web API, e.g. for
+	# diagnostics. Only exact matches are allowed. Commands are killed after
+	# the timeout (default 30s).
+	exec:
+	  timeout: 30s
+	  commands:
+	  - ifconfig
+	  - mca-status
+	  - cat /proc/uptime
+
 	web:
 	  # The internal webserver will bind to this address. You really should not
 	  # use a publicly accessible IP address.
@@ -61,44 +185,109 @@ const ExampleYAML = `	---
 	  port: 8080
 `
 
-type sshAuthMethod struct {
-	Type     string `yaml:"type"`
-	Password string `yaml:"password"`
-	Path     string `yaml:"path"`
-}
-
 // Configuration maps config options to values
 type Configuration struct {
-	ConfigDirectory     string              `yaml:"config_directory"`
-	FirmwareDirectory   string              `yaml:"firmware_directory"`
-	SafeUpgradePaths    map[string][]string `yaml:"safe_upgrade_paths"`
-	reverseUpgradePaths map[string]string   // inferred from SafeUpgradePaths
-	InterfaceNames      []string            `yaml:"interfaces"`
-
-	SSHAuthMethods []sshAuthMethod `yaml:"ssh"`
-	sshAuthMethods []ssh.AuthMethod
+	Settings       `yaml:",inline"`
+	InterfaceNames []string `yaml:"interfaces"`
 
 	Web struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
 	} `yaml:"web"`
 
-	autoDiscoverer *discovery.Discover
+	fileName    string
+	settingsMtx sync.RWMutex
+
+	autoDiscoverer deviceLister
 	devices        *deviceCache
+	jobs           *jobList
+	bulks          *bulkList
+	events         *eventBus
+}
+
+// Settings are the config options which may change at runtime (see
+// Reload). Reload replaces them while holding both the device cache lock
+// and the settings lock, hence code running under the device cache lock
+// may access them directly. Everything else must use a snapshot (see
+// settings()).
+type Settings struct {
+	ConfigDirectory   string              `yaml:"config_directory"`
+	FirmwareDirectory string              `yaml:"firmware_directory"`
+	BackupDirectory   string              `yaml:"backup_directory"`
+	SafeUpgradePaths  map[string][]string `yaml:"safe_upgrade_paths"`
+	upgrades          *upgradeGraph       // inferred from SafeUpgradePaths
+	UpgradeTimeout    time.Duration       `yaml:"upgrade_timeout"`
+	firmwares         *firmwareCache
+
+	ConfigMatching []string        `yaml:"config_matching"`
+	Templates      TemplateOptions `yaml:"templates"`
+	templates      *configTemplates
+
+	SSHAuthMethods []sshAuthMethod    `yaml:"ssh"`
+	SSHCredentials []sshCredentialSet `yaml:"ssh_credentials"`
+	SSHPort        int                `yaml:"ssh_port"`
+	SSHPool        SSHPoolOptions     `yaml:"ssh_pool"`
+	sshCredentials []sshCredential
+	HostKeys       HostKeyOptions `yaml:"host_keys"`
+	hostKeys       *hostKeyStore
+
+	Bulk  BulkOptions  `yaml:"bulk"`
+	Drift DriftOptions `yaml:"drift"`
+	Exec  ExecOptions  `yaml:"exec"`
+
+	// ReloadOnChange enables watching the config file for modifications.
+	// Reloading is also possible via SIGHUP or the web API.
+	ReloadOnChange bool      `yaml:"reload_on_change"`
+	modTime        time.Time // of the config file, when it was read
+	generation     uint64    // incremented by Reload
+}
+
+// settings returns a snapshot of the current settings.
+func (c *Configuration) settings() Settings {
+	c.settingsMtx.RLock()
+	defer c.settingsMtx.RUnlock()
+	return c.Settings
 }
 
 // LoadConfig reads a YAML file and converts it to a config object
 func LoadConfig(fileName string) (c *Configuration, errs []error) {
-	c = &Configuration{}
+	c, errs = parseConfig(fileName)
+	if c == nil {
+		return // config file unreadable
+	}
+
+	c.firmwares = &firmwareCache{files: make(map[string]*FirmwareFile)}
+	var err error
+	if c.hostKeys, err = loadHostKeys(c.HostKeys, filepath.Dir(fileName)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		c.devices = &deviceCache{
+			list: make(map[string]*Device),
+		}
+		c.events = newEventBus()
+		c.jobs = &jobList{events: c.events}
+		c.bulks = &bulkList{}
+	}
+	checkFirmwares(&c.Settings, c.events)
+	return
+}
+
+// parseConfig reads and validates a config file. Unlike LoadConfig, it
+// neither loads the host keys, nor sets up any runtime state (firmware
+// cache, device cache, event bus, jobs). If the file can't be read or
+// decoded, c is nil.
+func parseConfig(fileName string) (c *Configuration, errs []error) {
+	c = &Configuration{fileName: fileName}
+	c.modTime = configModTime(fileName)
 
 	file, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		errs = append(errs, err)
-		return
+		return nil, []error{err}
 	}
 	if err := yaml.Unmarshal(file, &c); err != nil {
-		errs = append(errs, err)
-		return
+		return nil, []error{err}
 	}
 
 	// the following errors are recoverable
@@ -110,49 +299,58 @@ func LoadConfig(fileName string) (c *Configuration, errs []error) {
 	if dirErrs := checkDirectory("firmware_directory", base, &c.FirmwareDirectory); len(dirErrs) > 0 {
 		errs = append(errs, dirErrs...)
 	}
-
-	c.reverseUpgradePaths = make(map[string]string)
-	for target, sources := range c.SafeUpgradePaths {
-		for _, source := range sources {
-			if _, exists := c.reverseUpgradePaths[source]; exists {
-				errs = append(errs, fmt.Errorf("multiple upgrade paths for %s detected", source))
-				continue
-			}
-			c.reverseUpgradePaths[source] = target
+	if c.BackupDirectory != "" {
+		if dirErrs := checkDirectory("backup_directory", base, &c.BackupDirectory); len(dirErrs) > 0 {
+			errs = append(errs, dirErrs...)
 		}
 	}
 
+	if len(c.ConfigMatching) == 0 {
+		c.ConfigMatching = defaultConfigMatching
+	}
+	if ruleErrs := checkConfigMatching(c.ConfigMatching); len(ruleErrs) > 0 {
+		errs = append(errs, ruleErrs...)
+	}
+
+	var tplErrs []error
+	if c.templates, tplErrs = loadTemplates(c.Templates, base); len(tplErrs) > 0 {
+		errs = append(errs, tplErrs...)
+	}
+
+	c.upgrades = newUpgradeGraph(c.SafeUpgradePaths)
+	if c.UpgradeTimeout <= 0 {
+		c.UpgradeTimeout = 10 * time.Minute
+	}
+
 	if len(c.InterfaceNames) == 0 {
 		errs = append(errs, fmt.Errorf("missing option interfaces, at least one name (or '*') must be given"))
 	}
 
+	if c.Bulk.Concurrency <= 0 {
+		c.Bulk.Concurrency = 1
+	}
+	if c.Drift.Concurrency <= 0 {
+		c.Drift.Concurrency = 1
+	}
+	if c.Exec.Timeout <= 0 {
+		c.Exec.Timeout = defaultExecTimeout
+	}
+
 	if c.Web.Port <= 0 || c.Web.Port > math.MaxUint16 {
 		errs = append(errs, fmt.Errorf("config option web.port out of range"))
 	}
 
-	c.sshAuthMethods = make([]ssh.AuthMethod, 0, len(c.SSHAuthMethods))
-	for _, m := range c.SSHAuthMethods {
-		switch m.Type {
-		case "", "password": // Type=="" is an alias for password
-			if m.Password != "" {
-				c.sshAuthMethods = append(c.sshAuthMethods, ssh.Password(m.Password))
-			}
-		case "ssh-agent":
-			if a := pssh.Agent(); a != nil {
-				c.sshAuthMethods = append(c.sshAuthMethods, a)
-			}
-		case "keyfile":
-			if key, ok := pssh.ReadPrivateKey(m.Path, m.Password); ok {
-				c.sshAuthMethods = append(c.sshAuthMethods, key)
-			}
-		default:
-			errs = append(errs, fmt.Errorf("unknown auth method %q", m.Type))
-		}
+	if c.SSHPort <= 0 {
+		c.SSHPort = defaultSSHPort
 	}
-
-	if len(errs) == 0 {
-		c.devices = &deviceCache{
-			list: make(map[string]*Device),
+	var credErrs []error
+	if c.sshCredentials, credErrs = buildCredentials("ssh", c.SSHAuthMethods, 0); len(credErrs) > 0 {
+		errs = append(errs, credErrs...)
+	}
+	for i := range c.SSHCredentials {
+		set := &c.SSHCredentials[i]
+		if set.credentials, credErrs = buildCredentials(fmt.Sprintf("ssh_credentials.%d", i), set.Methods, set.Port); len(credErrs) > 0 {
+			errs = append(errs, credErrs...)
 		}
 	}
 
@@ -187,10 +385,10 @@ func checkDirectory(name string, baseDir string, dir *string) (errs []error) {
 
 // FirmwareImages prepares a list of firmware names
 func (c *Configuration) FirmwareImages() []string {
-	return goldflags.ReadDir(c.FirmwareDirectory)
+	return goldflags.ReadDir(c.settings().FirmwareDirectory)
 }
 
 // DeviceConfigs prepares a list of device configuration names
 func (c *Configuration) DeviceConfigs() []string {
-	return goldflags.ReadDir(c.ConfigDirectory)
+	return goldflags.ReadDir(c.settings().ConfigDirectory)
 }
@@ -3,8 +3,9 @@ package provisioner
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"log"
-	"reflect"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -20,26 +21,130 @@ import (
 // It also knows how to communicate with the device.
 type Device struct {
 	*discovery.Device
-	IPAddress        string
-	firmwarePath     string
+	RebootedAt time.Time
+
+	jobs   *jobList
+	logs   *logBuffer
+	events *eventBus
+
+	busy       bool
+	busyMsg    string
+	job        *Job   // currently running
+	queue      []*Job // waiting for execution
+	lastStatus string // last published Status()
+	lost       bool   // not seen for a while
+	cfg        *deviceSettings
+	busyMtx    sync.RWMutex
+
+	// stateMtx guards the discovery data (merged by the device cache)
+	// and RebootedAt. Readers outside the cache use Snapshot.
+	stateMtx sync.RWMutex
+
+	drift  driftState
+	access accessState
+	pool   sshPool
+}
+
+// deviceSettings holds everything the device cache derives from the
+// configuration for a single device. It is never modified, but replaced
+// as a whole, so that jobs work with a consistent view even when the
+// configuration is reloaded in the meantime.
+type deviceSettings struct {
+	ipAddress        string
+	upgradePath      []upgradeStep
 	systemConfigPath string
-	RebootedAt       time.Time
+	configTemplate   string
+	configRule       string
+	templates        *configTemplates
+	firmwares        *firmwareCache
+
+	credentials     []sshCredential
+	sshPort         int // used if the device doesn't announce one
+	sshPool         SSHPoolOptions
+	credGeneration  uint64
+	hostKeys        *hostKeyStore
+	upgradeTimeout  time.Duration
+	backupDirectory string
+	exec            ExecOptions
+}
+
+func newDevice(dev *discovery.Device) *Device {
+	return &Device{
+		Device: dev,
+		logs:   newLogBuffer(deviceLogSize),
+		cfg:    &deviceSettings{},
+	}
+}
+
+// settings returns the current settings of the device. Callers must not
+// modify the result.
+func (d *Device) settings() *deviceSettings {
+	d.busyMtx.RLock()
+	defer d.busyMtx.RUnlock()
+	return d.cfg
+}
 
-	authMethods []ssh.AuthMethod
+// setSettings replaces the settings of the device.
+func (d *Device) setSettings(cfg *deviceSettings) {
+	d.busyMtx.Lock()
+	d.cfg = cfg
+	d.busyMtx.Unlock()
+}
 
-	busy    bool
-	busyMsg string
-	busyMtx sync.RWMutex
+// IPAddress returns the unique IP address of the device. It is empty, if
+// the device shares its addresses with others.
+func (d *Device) IPAddress() string {
+	return d.settings().ipAddress
 }
 
 // CanUpgrade indicates, whether new firmware image is available
 func (d *Device) CanUpgrade() bool {
-	return d.firmwarePath != ""
+	return len(d.settings().upgradePath) > 0
+}
+
+// UpgradePath lists the firmware versions the device will pass through
+// when upgrading.
+func (d *Device) UpgradePath() []string {
+	path := d.settings().upgradePath
+	list := make([]string, len(path))
+	for i, step := range path {
+		list[i] = step.Firmware
+	}
+	return list
 }
 
 // HasConfig indicates, whether system config is available
 func (d *Device) HasConfig() bool {
-	return d.systemConfigPath != ""
+	cfg := d.settings()
+	return cfg.systemConfigPath != "" || cfg.configTemplate != ""
+}
+
+// ConfigTemplate returns the name of the template used to generate the
+// system config. It is empty, if a device specific file exists.
+func (d *Device) ConfigTemplate() string {
+	return d.settings().configTemplate
+}
+
+// ConfigRule returns the config_matching rule, which selected the system
+// config (see Match* constants). It is empty, if no config is available.
+func (d *Device) ConfigRule() string {
+	return d.settings().configRule
+}
+
+// DesiredConfig returns the system config which would be written by
+// Provision(), either read from file or rendered from a template.
+func (d *Device) DesiredConfig() ([]byte, error) {
+	return d.desiredConfig(d.settings())
+}
+
+func (d *Device) desiredConfig(cfg *deviceSettings) ([]byte, error) {
+	switch {
+	case cfg.systemConfigPath != "":
+		return ioutil.ReadFile(cfg.systemConfigPath)
+	case cfg.configTemplate != "":
+		return cfg.templates.render(d, cfg.ipAddress)
+	}
+	return nil, fmt.Errorf("No device configuration found for %s", d.MacAddress)
 }
 
 // IsBusy states whether or not this Device is ready to receive commands.
@@ -49,94 +154,175 @@ func (d *Device) IsBusy() bool {
 	return d.busy
 }
 
-func (d *Device) setBusy(msg string) error {
-	d.busyMtx.Lock()
-	defer d.busyMtx.Unlock()
-	if d.busy {
-		return fmt.Errorf("Device is busy (%s)", d.busyMsg)
+// CurrentJob returns the job currently running on this device (or nil).
+func (d *Device) CurrentJob() *Job {
+	d.busyMtx.RLock()
+	defer d.busyMtx.RUnlock()
+	return d.job
+}
+
+// reportProgress attaches the progress of a file transfer to the current
+// job.
+func (d *Device) reportProgress(p pssh.Progress) {
+	if job := d.CurrentJob(); job != nil {
+		job.setProgress(p)
 	}
-	d.busy = true
-	d.busyMsg = msg
-	return nil
 }
 
 // Status gives a human-readable status information about this device. The
-// status may be "idle", "upgrading", "provisioning", or "rebooting". Note
-// that this status text only indicates a current event, when this device
-// is actually marked busy. Otherwise, you'll get the _last_ state.
+// status may be "idle", "drift" (running config differs from the desired
+// one), "queued", "upgrading", "provisioning", or "rebooting". Note that
+// this status text only indicates a current event, when this device is
+// actually marked busy. Otherwise, you'll get the _last_ state.
 func (d *Device) Status() string {
-	if d.IsBusy() {
-		return d.busyMsg
+	d.busyMtx.RLock()
+	busy, msg := d.busy, d.busyMsg
+	d.busyMtx.RUnlock()
+
+	if busy {
+		if msg == "" {
+			return "queued"
+		}
+		return msg
 	}
-	if d.RebootedAt.After(d.LastSeenAt) {
+	if snap := d.Snapshot(); snap.RebootedAt.After(snap.LastSeenAt) {
 		return "rebooting"
 	}
+	if d.Drifted() {
+		return "drift"
+	}
 	return "idle"
 }
 
-// Provision updates the system config on the remote device.
-func (d *Device) Provision() error {
+// Provision enqueues a job, which updates the system config on the remote
+// device.
+func (d *Device) Provision() (*Job, error) {
 	if !d.HasConfig() {
-		return fmt.Errorf("No device configuration found for %s", d.MacAddress)
+		return nil, fmt.Errorf("No device configuration found for %s", d.MacAddress)
 	}
 
-	return d.withSSHClient("provisioning", d.doProvision)
+	return d.enqueue("provision", "provisioning", func() error {
+		return d.withSSHClient(d.doProvision)
+	}), nil
 }
 
 // runs in background-goroutine
-func (d *Device) doProvision(c *ssh.Client) {
+func (d *Device) doProvision(c *ssh.Client) error {
 	d.log("Start provisioning...")
-	var sessionError error
 
-	remotePath := "/tmp/system.cfg"
-	if sessionError = pssh.UploadFile(c, d.systemConfigPath, remotePath); sessionError != nil {
-		d.log("Upload failed: %v", sessionError)
-		return
+	cfg := d.settings()
+	source := cfg.systemConfigPath
+	if source == "" {
+		source = "template " + cfg.configTemplate
+	}
+	content, err := d.desiredConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("Could not generate configuration: %v", err)
+	}
+	return d.writeConfig(c, content, source)
+}
+
+// writeConfig replaces the current configuration with the given content
+// (see saveConfig) and reboots the device.
+func (d *Device) writeConfig(c *ssh.Client, content []byte, source string) error {
+	if err := d.saveConfig(c, content, source); err != nil {
+		return err
+	}
+	return d.rebootAfterSave(c)
+}
+
+// saveConfig backs up the current configuration, replaces it with the
+// given content and saves it to flash.
+func (d *Device) saveConfig(c *ssh.Client, content []byte, source string) error {
+	if err := d.backupConfig(c); err != nil {
+		return fmt.Errorf("Backup failed: %v", err)
 	}
-	d.log("local(%s) -> remote(%s) 100%%", d.systemConfigPath, remotePath)
 
-	if _, sessionError = pssh.ExecuteCommand(c, "/usr/bin/cfgmtd -w -p /etc/"); sessionError != nil {
-		d.log("Could not save configuration: %v", sessionError)
-		return
+	if err := pssh.Upload(c, content, remoteConfigPath); err != nil {
+		return fmt.Errorf("Upload failed: %v", err)
+	}
+	d.log("local(%s) -> remote(%s) 100%%", source, remoteConfigPath)
+
+	if _, err := pssh.ExecuteCommand(c, "/usr/bin/cfgmtd -w -p /etc/"); err != nil {
+		return fmt.Errorf("Could not save configuration: %v", err)
 	}
 	d.log("Configuration saved")
+	d.resetDrift()
+	return nil
+}
 
-	if _, sessionError = pssh.ExecuteCommand(c, "/usr/bin/reboot"); sessionError != nil {
-		d.log("Reboot failed: %v", sessionError)
-		return
+// rebootAfterSave reboots the device to activate a saved configuration.
+func (d *Device) rebootAfterSave(c *ssh.Client) error {
+	if _, err := pssh.ExecuteCommand(c, "/usr/bin/reboot"); err != nil {
+		return fmt.Errorf("Reboot failed: %v", err)
 	}
 	d.markReboot(5 * time.Second)
 	d.log("Reboot succeeded")
+	return nil
 }
 
-// Upgrade uploads the firmware image to the remote device and starts
-// the upgrade process.
-func (d *Device) Upgrade() error {
-	if !d.CanUpgrade() {
-		return fmt.Errorf("cannot safely upgrade device %s", d.MacAddress)
+// Upgrade enqueues a job, which walks along the upgrade path: for each
+// step, the firmware image is uploaded to the remote device, the upgrade
+// process is started and we wait for the device to come back.
+func (d *Device) Upgrade() (*Job, error) {
+	cfg := d.settings()
+	if len(cfg.upgradePath) == 0 {
+		return nil, fmt.Errorf("cannot safely upgrade device %s", d.MacAddress)
 	}
-	return d.withSSHClient("upgrading", d.doUpgrade)
+
+	path := cfg.upgradePath
+	return d.enqueue("upgrade", "upgrading", func() error {
+		job := d.CurrentJob()
+		for i, step := range path {
+			d.log("Upgrade step %d/%d: %s", i+1, len(path), step.Firmware)
+			err := d.withSSHClient(func(c *ssh.Client) error {
+				return d.doUpgrade(c, cfg.firmwares, step.Image)
+			})
+			if err != nil {
+				job.setResult(UpgradeFailed)
+				return err
+			}
+
+			result, err := d.verifyUpgrade(step.Firmware, cfg.upgradeTimeout)
+			job.setResult(result)
+			if err != nil {
+				d.alert("Upgrade to %s: %v", step.Firmware, err)
+				return err
+			}
+		}
+		return nil
+	}), nil
 }
 
-func (d *Device) doUpgrade(c *ssh.Client) {
+func (d *Device) doUpgrade(c *ssh.Client, firmwares *firmwareCache, firmwarePath string) error {
 	d.log("Start upgrading...")
-	var sessionError error
+
+	fw := firmwares.lookup(filepath.Dir(firmwarePath), filepath.Base(firmwarePath))
+	if fw.Err != nil {
+		return fmt.Errorf("Invalid firmware image: %v", fw.Err)
+	}
+	img := fw.Image
+	if err := img.Compatible(d.Snapshot().Firmware); err != nil {
+		return fmt.Errorf("Incompatible firmware image: %v", err)
+	}
+	d.log("Firmware image %s (%d partitions, %d bytes, sha256 %s)", img.Version, len(img.Partitions), img.Size, fw.SHA256)
 
 	remotePath := "/tmp/fwupdate.bin"
-	if sessionError = pssh.UploadFile(c, d.firmwarePath, remotePath); sessionError != nil {
-		d.log("Upload failed: %v", sessionError)
-		return
+	if err := pssh.UploadFile(c, firmwarePath, remotePath, d.reportProgress); err != nil {
+		return fmt.Errorf("Upload failed: %v", err)
 	}
-	d.log("local(%s) -> remote(%s) 100%%", d.firmwarePath, remotePath)
+	d.log("local(%s) -> remote(%s) 100%%", firmwarePath, remotePath)
 
-	_, sessionError = pssh.ExecuteCommand(c, "/usr/bin/ubntbox fwupdate.real -c "+remotePath)
-	if sessionError != nil {
-		d.log("Firmware check failed: %v", sessionError)
-		return
+	if err := d.verifyRemoteFile(c, remotePath, fw); err != nil {
+		return err
+	}
+
+	if _, err := pssh.ExecuteCommand(c, "/usr/bin/ubntbox fwupdate.real -c "+remotePath); err != nil {
+		return fmt.Errorf("Firmware check failed: %v", err)
 	}
 	d.log("Firmware check succeeded")
 
-	sessionError = pssh.WithinSession(c, func(s *ssh.Session) error {
+	sessionError := pssh.WithinSession(c, func(s *ssh.Session) error {
 		reader, err := s.StderrPipe()
 		if err != nil {
 			return err
@@ -163,79 +349,249 @@ func (d *Device) doUpgrade(c *ssh.Client) {
 	})
 
 	if sessionError != nil {
-		d.log("Could not upgrade firmware: %v", sessionError)
-		return
+		return fmt.Errorf("Could not upgrade firmware: %v", sessionError)
 	}
 	d.markReboot(30 * time.Second)
 	d.log("Firmware upgrade succeeded")
+	return nil
 }
 
-// Reboot issues a reboot on the device.
-func (d *Device) Reboot() error {
-	return d.withSSHClient("rebooting", func(c *ssh.Client) {
-		if _, sessionError := pssh.ExecuteCommand(c, "/usr/bin/reboot"); sessionError != nil {
-			d.log("Reboot failed: %v", sessionError)
-			return
+// verifyRemoteFile compares the checksum of an uploaded file with the
+// local one. Devices lacking sha256sum are checked with md5sum.
+func (d *Device) verifyRemoteFile(c *ssh.Client, remotePath string, fw *FirmwareFile) error {
+	for _, check := range []struct{ cmd, expected string }{
+		{"sha256sum", fw.SHA256},
+		{"md5sum", fw.MD5},
+	} {
+		out, err := pssh.Output(c, check.cmd+" "+remotePath)
+		if err != nil {
+			d.log("%s failed: %v", check.cmd, err)
+			continue
 		}
-		d.markReboot(5 * time.Second)
-		d.log("Reboot succeeded")
-	})
-}
 
-func (d *Device) withSSHClient(msg string, callback func(*ssh.Client)) error {
-	if err := d.setBusy(msg); err != nil {
-		return err
+		fields := strings.Fields(out)
+		if len(fields) == 0 || fields[0] != check.expected {
+			return fmt.Errorf("Checksum mismatch after upload (%s: %q)", check.cmd, strings.TrimSpace(out))
+		}
+		d.log("Remote checksum verified (%s)", check.cmd)
+		return nil
 	}
+	return fmt.Errorf("Could not verify checksum of uploaded file")
+}
 
-	client := d.getSSHClient()
-	if client == nil {
-		d.busy = false
-		return fmt.Errorf("Could not obtain SSH client")
-	}
-	d.log("Got a client")
+// Results of a firmware upgrade job (see Job.Result).
+const (
+	UpgradeSucceeded    = "succeeded"      // device returned with expected firmware
+	UpgradeFailed       = "failed"         // upgrade process failed
+	UpgradeMismatch     = "wrong firmware" // device returned with other firmware
+	UpgradeDidNotReturn = "did not return" // device wasn't rediscovered in time
+)
 
-	go func() {
-		callback(client)
-		d.log("Callback succeeded")
-		d.busy = false
-		client.Close()
-	}()
+// verifyUpgrade blocks until the device has been rediscovered after a
+// reboot, and compares the reported firmware version with the expected
+// one.
+func (d *Device) verifyUpgrade(firmware string, timeout time.Duration) (string, error) {
+	d.log("Waiting for device to return with firmware %s", firmware)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if snap := d.Snapshot(); snap.LastSeenAt.After(snap.RebootedAt) {
+			if snap.Firmware != firmware {
+				return UpgradeMismatch, fmt.Errorf("Device returned with firmware %s, expected %s", snap.Firmware, firmware)
+			}
+			d.log("Device returned with firmware %s", firmware)
+			return UpgradeSucceeded, nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return UpgradeDidNotReturn, fmt.Errorf("Device did not return within %v", timeout)
+}
 
-	return nil
+// Reboot enqueues a job, which issues a reboot on the device.
+func (d *Device) Reboot() (*Job, error) {
+	return d.enqueue("reboot", "rebooting", func() error {
+		return d.withSSHClient(func(c *ssh.Client) error {
+			if _, err := pssh.ExecuteCommand(c, "/usr/bin/reboot"); err != nil {
+				return fmt.Errorf("Reboot failed: %v", err)
+			}
+			d.markReboot(5 * time.Second)
+			d.log("Reboot succeeded")
+			return nil
+		})
+	}), nil
 }
 
-func (d *Device) getSSHClient() *ssh.Client {
-	clientConfig := &ssh.ClientConfig{
-		Timeout:         2 * time.Second,
-		User:            "ubnt",
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// enqueue creates a new job for this device. The jobs of a single device
+// are executed one after another (in background), in the order they were
+// enqueued.
+func (d *Device) enqueue(typ, status string, run func() error) *Job {
+	job := d.jobs.create(typ, d.MacAddress)
+	job.status = status
+	job.run = run
+
+	d.busyMtx.Lock()
+	d.queue = append(d.queue, job)
+	idle := !d.busy
+	if idle {
+		d.busy = true
+		d.busyMsg = ""
+	}
+	d.busyMtx.Unlock()
+
+	if idle {
+		go d.processQueue()
 	}
+	d.notifyStatus()
+	return job
+}
 
-	for i, m := range d.authMethods {
-		clientConfig.Auth = []ssh.AuthMethod{m}
-		authType := reflect.TypeOf(m).String()
+func (d *Device) processQueue() {
+	defer d.notifyStatus()
 
-		client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", d.IPAddress), clientConfig)
+	for job := d.nextJob(); job != nil; job = d.nextJob() {
+		d.notifyStatus()
+		d.log("Starting job %d (%s)", job.ID, job.Type)
+		err := job.run()
 		if err != nil {
-			d.log("(try %d) %s authentication failed with %v", i+1, authType, err)
-			continue
+			d.log("%v", err)
 		}
+		d.log("Job %d finished", job.ID)
+		job.finish(err)
+	}
+}
 
-		d.log("(try %d) %s authentication succeeded", i+1, authType)
-		return client
+// nextJob pops the next runnable job off the queue. If there is none,
+// the device is marked idle.
+func (d *Device) nextJob() *Job {
+	d.busyMtx.Lock()
+	defer d.busyMtx.Unlock()
+
+	for len(d.queue) > 0 {
+		job := d.queue[0]
+		d.queue = d.queue[1:]
+		if job.start() {
+			d.job = job
+			d.busyMsg = job.status
+			return job
+		}
 	}
 
+	d.busy = false
+	d.job = nil
 	return nil
 }
 
-// Prints a log message prefixed with "[Device aa:bb:cc:dd:ee:ff]".
+func (d *Device) withSSHClient(callback func(*ssh.Client) error) error {
+	client, release, err := d.acquireSSH()
+	if err != nil {
+		return fmt.Errorf("Could not obtain SSH client: %v", err)
+	}
+	defer release()
+	d.log("Got a client")
+
+	return callback(client)
+}
+
+// sshPortFor returns the port to connect to: the one of the credential
+// set, the discovered or the default one.
+func (d *Device) sshPortFor(cfg *deviceSettings, cred sshCredential) int {
+	announced := d.Snapshot().SSHPort
+	switch {
+	case cred.port > 0:
+		return cred.port
+	case announced > 0:
+		return int(announced)
+	case cfg.sshPort > 0:
+		return cfg.sshPort
+	}
+	return defaultSSHPort
+}
+
+// Log returns the most recent log lines of this device, oldest first.
+func (d *Device) Log() []LogLine {
+	return d.logs.list()
+}
+
+// FollowLog returns the most recent log lines of this device and a
+// channel receiving all future lines. Call the returned function when
+// you're done reading from the channel.
+func (d *Device) FollowLog() ([]LogLine, <-chan LogLine, func()) {
+	return d.logs.subscribe()
+}
+
+// Prints a log message prefixed with "[Device aa:bb:cc:dd:ee:ff]". The
+// message is also captured by the device's log buffer and the currently
+// running job.
 func (d *Device) log(message string, v ...interface{}) {
-	log.Printf(fmt.Sprintf("[Device %s] %s", d.MacAddress, message), v...)
+	msg := fmt.Sprintf(message, v...)
+	log.Printf("[Device %s] %s", d.MacAddress, msg)
+	d.logs.append(msg)
+	if job := d.CurrentJob(); job != nil {
+		job.logf("%s", msg)
+	}
+}
+
+// alert logs a message and publishes it as alert.
+func (d *Device) alert(message string, v ...interface{}) {
+	msg := fmt.Sprintf(message, v...)
+	d.log("ALERT: %s", msg)
+	d.events.publish(&Event{Type: EventAlert, Device: d, Message: msg})
+}
+
+// notifyStatus publishes an event, if the status has changed since the
+// last call.
+func (d *Device) notifyStatus() {
+	status := d.Status()
+
+	d.busyMtx.Lock()
+	changed := status != d.lastStatus
+	d.lastStatus = status
+	d.busyMtx.Unlock()
+
+	if changed {
+		d.events.publish(&Event{Type: EventDeviceStatus, Device: d, Message: status})
+	}
 }
 
 // markReboot sets the RebootedAt flat to a time in the future. This is
 // used to detect reboot cycles, which may not be effective immediately,
 // and hence makes the device misleadingly available/idle in the UI.
 func (d *Device) markReboot(inFuture time.Duration) {
+	d.stateMtx.Lock()
 	d.RebootedAt = time.Now().Add(inFuture)
+	d.stateMtx.Unlock()
+	d.dropSSH()
+}
+
+// DeviceState is a consistent copy of the discovery data and the reboot
+// timestamp, which are guarded by stateMtx.
+type DeviceState struct {
+	discovery.Device
+	RebootedAt time.Time
+}
+
+// Snapshot returns a copy of the discovery data and the reboot timestamp.
+// The copy shares the IPAddresses map, which must not be modified.
+func (d *Device) Snapshot() DeviceState {
+	d.stateMtx.RLock()
+	defer d.stateMtx.RUnlock()
+
+	return DeviceState{
+		Device:     *d.Device,
+		RebootedAt: d.RebootedAt,
+	}
+}
+
+// RecentlySeen tells whether the device has been seen within the given
+// time period.
+func (d *Device) RecentlySeen(dur time.Duration) bool {
+	snap := d.Snapshot()
+	return snap.RecentlySeen(dur)
+}
+
+// merge updates the discovery data while holding the state lock.
+func (d *Device) merge(dev *discovery.Device) {
+	d.stateMtx.Lock()
+	d.Device.Merge(dev)
+	d.stateMtx.Unlock()
 }
@@ -2,12 +2,11 @@ package provisioner
 
 import (
 	"log"
-	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/digineo/goldflags"
 	"github.com/digineo/ubnt-tools/discovery"
 )
 
@@ -17,16 +16,59 @@ type deviceCache struct {
 	sync.RWMutex
 }
 
+// deviceLister provides the list of discovered devices. It is
+// implemented by discovery.Discover.
+type deviceLister interface {
+	List() []*discovery.Device
+}
+
+// lostAfter defines how long a device may stay silent until we consider
+// it lost.
+const lostAfter = 1 * time.Minute
+
 // StartAutoDiscover starts the UBNT auto discovery mechanism. See
 // discovery.AutoDiscover for details.
 func (c *Configuration) StartAutoDiscover(notify discovery.NotifyHandler) (d *discovery.Discover, err error) {
 	d, err = discovery.AutoDiscover(notify, c.InterfaceNames...)
 	if err == nil {
+		c.devices.Lock()
 		c.autoDiscoverer = d
+		c.devices.Unlock()
+
+		updates := make(chan struct{}, 1)
+		d.OnUpdate(func(*discovery.Device) {
+			select {
+			case updates <- struct{}{}:
+			default: // refresh already pending
+			}
+		})
+		go c.watchDevices(updates)
+		go c.watchDrift()
+		go c.watchConfigFile()
 	}
 	return
 }
 
+// watchDevices keeps the device cache in sync with the auto-discoverer,
+// so that events are published as soon as something happens.
+func (c *Configuration) watchDevices(updates <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-updates:
+			// give concurrent responses a chance to arrive
+			time.Sleep(250 * time.Millisecond)
+		case <-ticker.C:
+		}
+
+		c.devices.Lock()
+		c.refreshCache()
+		c.devices.Unlock()
+	}
+}
+
 // GetDevices returns an array with all discovered devices.
 func (c *Configuration) GetDevices() (list []*Device) {
 	c.updateCache()
@@ -58,15 +100,21 @@ func (c *Configuration) updateCache() {
 	if time.Since(c.devices.updated) < 1*time.Second {
 		return
 	}
+	c.refreshCache()
+}
+
+// refreshCache merges the auto-discoverer's device list into the cache
+// and publishes events for all changes. The caller must hold the lock.
+func (c *Configuration) refreshCache() {
 	if c.autoDiscoverer == nil {
 		log.Printf("[device cache] no auto-discoverer found")
 		return
 	}
 
-	log.Printf("[device cache] updating")
 	discovered := c.autoDiscoverer.List()
-	seen := make(map[string]int) // IP address -> # of devices with this address
-	list := c.devices.list       // mac -> Device
+	seen := make(map[string]int)         // IP address -> # of devices with this address
+	list := c.devices.list               // mac -> Device
+	events := make(map[string]EventType) // mac -> event
 
 	for _, dev := range discovered {
 		for _, addrs := range dev.IPAddresses {
@@ -75,49 +123,102 @@ func (c *Configuration) updateCache() {
 			}
 		}
 		if old, found := list[dev.MacAddress]; found {
-			old.Device.Merge(dev)
-			old.firmwarePath = ""
-			old.systemConfigPath = ""
+			if deviceChanged(old.Device, dev) {
+				events[dev.MacAddress] = EventDeviceChanged
+			}
+			old.merge(dev)
 		} else {
-			list[dev.MacAddress] = &Device{Device: dev}
+			added := newDevice(dev)
+			added.jobs = c.jobs
+			added.events = c.events
+			list[dev.MacAddress] = added
+			events[dev.MacAddress] = EventDeviceAdded
 		}
 	}
 
 	// inject additional information
-	for _, dev := range list {
+	for mac, dev := range list {
+		var ipAddress string
+
 		// unique IP addresses
 		for _, addrs := range dev.IPAddresses {
-			dev.IPAddress = ""
+			ipAddress = ""
 			for _, ip := range addrs {
 				if ip == "192.168.1.20" {
 					continue
 				}
-				if dev.IPAddress == "" && seen[ip] == 1 {
-					dev.IPAddress = ip
+				if ipAddress == "" && seen[ip] == 1 {
+					ipAddress = ip
 				}
 			}
 		}
 
-		// Path to system config
-		if cfgPath := filepath.Join(c.ConfigDirectory, sanitizeMac(dev.MacAddress)+".cfg"); goldflags.PathExist(cfgPath) {
-			dev.systemConfigPath = cfgPath
-		}
+		// Path to system config (or template)
+		cfgRule, cfgPath, cfgTemplate := c.matchConfig(dev)
+
+		// Firmware upgrade path
+		upgradePath := c.upgrades.plan(dev.Firmware, c.FirmwareDirectory, c.image)
 
-		// Path to firmware
-		if target, ok := c.reverseUpgradePaths[dev.Firmware]; ok {
-			if fwPath := filepath.Join(c.FirmwareDirectory, target); goldflags.PathExist(fwPath) {
-				dev.firmwarePath = fwPath
+		old := dev.settings()
+		if ipAddress != old.ipAddress || cfgPath != old.systemConfigPath || cfgTemplate != old.configTemplate || cfgRule != old.configRule || !reflect.DeepEqual(upgradePath, old.upgradePath) {
+			if _, ok := events[mac]; !ok {
+				events[mac] = EventDeviceChanged
+			}
+		}
+		dev.setSettings(&deviceSettings{
+			ipAddress:        ipAddress,
+			systemConfigPath: cfgPath,
+			configTemplate:   cfgTemplate,
+			configRule:       cfgRule,
+			templates:        c.templates,
+			upgradePath:      upgradePath,
+			firmwares:        c.firmwares,
+
+			// SSH auth methods
+			credentials:     c.credentialsFor(dev),
+			sshPort:         c.SSHPort,
+			sshPool:         c.SSHPool,
+			credGeneration:  c.generation,
+			hostKeys:        c.hostKeys,
+			upgradeTimeout:  c.UpgradeTimeout,
+			backupDirectory: c.BackupDirectory,
+			exec:            c.Exec,
+		})
+
+		// lost and found
+		if recent := dev.RecentlySeen(lostAfter); recent == dev.lost {
+			dev.lost = !recent
+			if dev.lost {
+				events[mac] = EventDeviceLost
+			} else if _, ok := events[mac]; !ok {
+				events[mac] = EventDeviceChanged
 			}
 		}
+	}
 
-		// SSH auth methods
-		dev.authMethods = c.sshAuthMethods
+	for mac, typ := range events {
+		c.events.publish(&Event{Type: typ, Device: list[mac]})
+	}
+	for _, dev := range list {
+		dev.notifyStatus()
 	}
 
 	c.devices.updated = time.Now()
 	return
 }
 
+// deviceChanged compares the relevant discovery information of two
+// devices.
+func deviceChanged(a, b *discovery.Device) bool {
+	return a.Model != b.Model ||
+		a.Platform != b.Platform ||
+		a.Hostname != b.Hostname ||
+		a.Firmware != b.Firmware ||
+		a.Essid != b.Essid ||
+		a.WirelessMode != b.WirelessMode ||
+		!reflect.DeepEqual(a.IPAddresses, b.IPAddresses)
+}
+
 func sanitizeMac(mac string) string {
 	return strings.ToLower(strings.Replace(mac, ":", "", -1))
 }
@@ -0,0 +1,124 @@
+package provisioner
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLister replaces the auto-discoverer.
+type testLister struct {
+	devices []*discovery.Device
+	mtx     sync.Mutex
+}
+
+func (l *testLister) List() (list []*discovery.Device) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for _, dev := range l.devices {
+		list = append(list, dev.Clone())
+	}
+	return
+}
+
+func (l *testLister) set(devices ...*discovery.Device) {
+	l.mtx.Lock()
+	l.devices = devices
+	l.mtx.Unlock()
+}
+
+func testDeviceCache(l *testLister) *Configuration {
+	return &Configuration{
+		Settings:       Settings{upgrades: newUpgradeGraph(nil)},
+		autoDiscoverer: l,
+		devices:        &deviceCache{list: make(map[string]*Device)},
+		events:         newEventBus(),
+	}
+}
+
+func TestRefreshCacheDuringAcquire(t *testing.T) {
+	assert := assert.New(t)
+
+	port, _, stop := testSSHServer(t)
+	defer stop()
+
+	const mac = "00:11:22:aa:bb:cc"
+	l := &testLister{}
+	l.set(&discovery.Device{
+		MacAddress:  mac,
+		IPAddresses: map[string][]string{mac: {"127.0.0.1"}},
+		LastSeenAt:  time.Now(),
+	})
+	c := testDeviceCache(l)
+	c.sshCredentials, _ = buildCredentials("ssh", []sshAuthMethod{{Password: "ubnt"}}, port)
+
+	dev := c.FindDevice(mac)
+	if !assert.NotNil(dev) {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			c.devices.Lock()
+			c.generation++ // like a reload
+			c.refreshCache()
+			c.devices.Unlock()
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		_, release, err := dev.acquireSSH()
+		if !assert.NoError(err) {
+			break
+		}
+		release()
+	}
+	<-done
+	dev.dropSSH()
+}
+
+func TestRefreshCacheEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	const mac = "00:11:22:aa:bb:cc"
+	l := &testLister{}
+	c := testDeviceCache(l)
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	refresh := func(dev discovery.Device) (types []EventType) {
+		l.set(&dev)
+		c.devices.Lock()
+		c.refreshCache()
+		c.devices.Unlock()
+
+		for len(events) > 0 {
+			if e := <-events; e.Type != EventDeviceStatus {
+				assert.Equal(mac, e.Device.MacAddress)
+				types = append(types, e.Type)
+			}
+		}
+		return
+	}
+
+	dev := discovery.Device{MacAddress: mac, Hostname: "ap-1", LastSeenAt: time.Now()}
+	assert.Equal([]EventType{EventDeviceAdded}, refresh(dev))
+	assert.Empty(refresh(dev))
+
+	dev.Hostname = "ap-2"
+	assert.Equal([]EventType{EventDeviceChanged}, refresh(dev))
+
+	dev.LastSeenAt = time.Now().Add(-2 * lostAfter)
+	assert.Equal([]EventType{EventDeviceLost}, refresh(dev))
+	assert.Empty(refresh(dev))
+
+	// found again
+	dev.LastSeenAt = time.Now()
+	assert.Equal([]EventType{EventDeviceChanged}, refresh(dev))
+}
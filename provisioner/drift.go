@@ -0,0 +1,126 @@
+package provisioner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+)
+
+// DriftOptions controls the periodic comparison of running and desired
+// configurations. An Interval of 0 disables drift detection.
+type DriftOptions struct {
+	Interval    time.Duration `yaml:"interval"`
+	Concurrency int           `yaml:"concurrency"`
+}
+
+// driftState holds the result of the last drift check of a device.
+type driftState struct {
+	diff      *syscfg.Diff
+	checkedAt time.Time
+	err       error
+	resets    uint64 // incremented by resetDrift
+	mtx       sync.RWMutex
+}
+
+// Drift returns the result of the last drift check: the changes needed
+// to restore the desired configuration, the time of the check and an
+// error, if the check failed. diff is nil, if no check was performed.
+func (d *Device) Drift() (diff *syscfg.Diff, checkedAt time.Time, err error) {
+	d.drift.mtx.RLock()
+	defer d.drift.mtx.RUnlock()
+	return d.drift.diff, d.drift.checkedAt, d.drift.err
+}
+
+// Drifted is true, if the running configuration differs from the
+// desired one (according to the last check).
+func (d *Device) Drifted() bool {
+	diff, _, _ := d.Drift()
+	return diff != nil && !diff.Empty()
+}
+
+func (d *Device) driftResets() uint64 {
+	d.drift.mtx.RLock()
+	defer d.drift.mtx.RUnlock()
+	return d.drift.resets
+}
+
+// setDrift stores the result of a check, which started after the given
+// number of resets. If the configuration has been written since, the
+// result is outdated and discarded.
+func (d *Device) setDrift(resets uint64, diff *syscfg.Diff, err error) bool {
+	d.drift.mtx.Lock()
+	defer d.drift.mtx.Unlock()
+
+	if resets != d.drift.resets {
+		return false
+	}
+	d.drift.diff, d.drift.checkedAt, d.drift.err = diff, time.Now(), err
+	return true
+}
+
+// resetDrift forgets the last check result (i.e. after the configuration
+// has been written).
+func (d *Device) resetDrift() {
+	d.drift.mtx.Lock()
+	d.drift.diff, d.drift.checkedAt, d.drift.err = nil, time.Time{}, nil
+	d.drift.resets++
+	d.drift.mtx.Unlock()
+}
+
+// checkDrift compares the running configuration with the desired one.
+func (d *Device) checkDrift() {
+	wasDrifted := d.Drifted()
+	resets := d.driftResets()
+
+	diff, err := d.ConfigDiff()
+	if !d.setDrift(resets, diff, err) {
+		d.log("Drift check outdated by configuration change, discarded")
+		return
+	}
+	if err != nil {
+		d.log("Drift check failed: %v", err)
+	}
+
+	if drifted := d.Drifted(); drifted != wasDrifted {
+		if drifted {
+			d.log("Configuration drift detected")
+		}
+		d.events.publish(&Event{Type: EventDeviceChanged, Device: d})
+		d.notifyStatus()
+	}
+}
+
+// watchDrift periodically checks all reachable, idle devices with a
+// desired configuration for drift. The interval is re-read after each
+// run, since the configuration may be reloaded.
+func (c *Configuration) watchDrift() {
+	for {
+		interval := c.settings().Drift.Interval
+		if interval <= 0 {
+			time.Sleep(reloadCheckInterval) // disabled
+			continue
+		}
+		time.Sleep(interval)
+		c.checkDrift()
+	}
+}
+
+func (c *Configuration) checkDrift() {
+	sem := make(chan struct{}, c.settings().Drift.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, dev := range c.GetDevices() {
+		if !dev.RecentlySeen(lostAfter) || dev.IsBusy() || !dev.HasConfig() || dev.IPAddress() == "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dev *Device) {
+			defer wg.Done()
+			dev.checkDrift()
+			<-sem
+		}(dev)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package provisioner
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDrift(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		running = "system.hostname=ap-1\n"
+		onRead  func()
+		mtx     sync.Mutex
+	)
+	port, stop := testExecServer(t, func(cmd string, stdout io.Writer) uint32 {
+		mtx.Lock()
+		content, hook := running, onRead
+		mtx.Unlock()
+
+		if hook != nil {
+			hook()
+		}
+		io.WriteString(stdout, content)
+		return 0
+	})
+	defer stop()
+
+	path := filepath.Join(t.TempDir(), "001122aabbcc.cfg")
+	assert.NoError(ioutil.WriteFile(path, []byte("system.hostname=ap-1\n"), 0644))
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "ubnt"}}, port)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", LastSeenAt: time.Now()})
+	dev.events = newEventBus()
+	dev.cfg.ipAddress = "127.0.0.1"
+	dev.cfg.credentials = creds
+	dev.cfg.systemConfigPath = path
+	dev.cfg.sshPool.Disabled = true
+
+	// in sync
+	dev.checkDrift()
+	diff, checkedAt, err := dev.Drift()
+	assert.NoError(err)
+	assert.False(checkedAt.IsZero())
+	assert.True(diff.Empty())
+	assert.Equal("idle", dev.Status())
+
+	// modified on the device
+	events, unsubscribe := dev.events.subscribe()
+	defer unsubscribe()
+	mtx.Lock()
+	running = "system.hostname=modified\n"
+	mtx.Unlock()
+	dev.checkDrift()
+	assert.True(dev.Drifted())
+	assert.Equal("drift", dev.Status())
+	if assert.Len(events, 2) {
+		assert.Equal(EventDeviceChanged, (<-events).Type)
+		e := <-events
+		assert.Equal(EventDeviceStatus, e.Type)
+		assert.Equal("drift", e.Message)
+	}
+
+	// provisioned while checking
+	dev.resetDrift()
+	mtx.Lock()
+	onRead = dev.resetDrift
+	mtx.Unlock()
+	dev.checkDrift()
+	diff, checkedAt, _ = dev.Drift()
+	assert.Nil(diff, "outdated result should be discarded")
+	assert.True(checkedAt.IsZero())
+	assert.False(dev.Drifted())
+}
+
+func TestDeviceStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", LastSeenAt: time.Now()})
+	assert.Equal("idle", dev.Status())
+
+	dev.drift.diff = &syscfg.Diff{Changed: []syscfg.Change{{Key: "system.hostname", Old: "a", New: "b"}}}
+	assert.Equal("drift", dev.Status())
+
+	dev.busy = true
+	assert.Equal("queued", dev.Status())
+	dev.busyMsg = "provisioning"
+	assert.Equal("provisioning", dev.Status())
+
+	dev.busy = false
+	dev.markReboot(time.Minute)
+	assert.Equal("rebooting", dev.Status())
+
+	dev.merge(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", LastSeenAt: time.Now().Add(2 * time.Minute)})
+	assert.Equal("drift", dev.Status())
+	dev.resetDrift()
+	assert.Equal("idle", dev.Status())
+}
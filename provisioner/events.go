@@ -0,0 +1,81 @@
+package provisioner
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType classifies an Event.
+type EventType string
+
+// Event types published by the provisioner.
+const (
+	EventDeviceAdded   EventType = "device-added"   // newly discovered
+	EventDeviceChanged EventType = "device-changed" // discovery data changed
+	EventDeviceLost    EventType = "device-lost"    // not seen for a while
+	EventDeviceStatus  EventType = "device-status"  // Device.Status() changed
+	EventJob           EventType = "job"            // Job.State() changed
+	EventJobLog        EventType = "job-log"        // new log line for a job
+	EventJobProgress   EventType = "job-progress"   // file transfer progress of a job
+	EventBulk          EventType = "bulk"           // bulk operation progress
+	EventAlert         EventType = "alert"          // something requires attention
+	EventConfigReload  EventType = "config-reload"  // config file reloaded
+)
+
+// Event describes something which happened to a device, job or bulk
+// operation. Depending on the Type, Device, Job and/or Bulk are set.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Device  *Device
+	Job     *Job
+	Bulk    *Bulk
+	Message string
+}
+
+// eventBus distributes events to all subscribers. Slow subscribers will
+// miss events instead of blocking the publisher.
+type eventBus struct {
+	subscribers map[chan *Event]struct{}
+	sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan *Event]struct{})}
+}
+
+func (b *eventBus) publish(e *Event) {
+	if b == nil {
+		return
+	}
+	e.Time = time.Now()
+
+	b.RLock()
+	defer b.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, 128)
+
+	b.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.Unlock()
+
+	return ch, func() {
+		b.Lock()
+		delete(b.subscribers, ch)
+		b.Unlock()
+	}
+}
+
+// Subscribe returns a channel receiving all future events. Call the
+// returned function when you're done reading from the channel.
+func (c *Configuration) Subscribe() (<-chan *Event, func()) {
+	return c.events.subscribe()
+}
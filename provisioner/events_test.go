@@ -0,0 +1,36 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBus(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newEventBus()
+	slow, unsubscribeSlow := b.subscribe()
+	fast, unsubscribeFast := b.subscribe()
+	defer unsubscribeFast()
+
+	// slow subscribers miss events instead of blocking the publisher
+	for i := 0; i < cap(slow)+10; i++ {
+		b.publish(&Event{Type: EventAlert})
+		e := <-fast
+		assert.False(e.Time.IsZero())
+	}
+	assert.Len(slow, cap(slow))
+
+	unsubscribeSlow()
+	for len(slow) > 0 {
+		<-slow
+	}
+	b.publish(&Event{Type: EventConfigReload})
+	assert.Len(slow, 0)
+	assert.Equal(EventConfigReload, (<-fast).Type)
+
+	// publishing without event bus is a no-op
+	var none *eventBus
+	none.publish(&Event{Type: EventAlert})
+}
@@ -0,0 +1,142 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultExecTimeout limits the run time of commands, unless configured
+// otherwise.
+const defaultExecTimeout = 30 * time.Second
+
+// ExecOptions restricts the commands operators may run on devices. Only
+// commands exactly matching an entry of Commands are allowed.
+type ExecOptions struct {
+	Timeout  time.Duration `yaml:"timeout"`
+	Commands []string      `yaml:"commands"`
+}
+
+// ErrCommandNotAllowed is returned by Device.Exec for commands missing in
+// the allowlist.
+type ErrCommandNotAllowed string
+
+func (e ErrCommandNotAllowed) Error() string {
+	return fmt.Sprintf("command %q is not allowed", string(e))
+}
+
+// Allowed checks whether cmd is in the allowlist.
+func (o *ExecOptions) Allowed(cmd string) bool {
+	cmd = strings.TrimSpace(cmd)
+	for _, c := range o.Commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecCommands returns the allowlist of commands.
+func (c *Configuration) ExecCommands() []string {
+	return c.settings().Exec.Commands
+}
+
+// Exec runs an allowed command on the device and waits for the result.
+// Non-zero exit codes are not treated as errors.
+func (d *Device) Exec(ctx context.Context, cmd string) (*pssh.Result, error) {
+	if !d.settings().exec.Allowed(cmd) {
+		return nil, ErrCommandNotAllowed(cmd)
+	}
+	return d.Run(ctx, cmd)
+}
+
+// Run executes any command on the device, without checking the allowlist
+// (see Exec). It is meant for command line tools.
+func (d *Device) Run(ctx context.Context, cmd string) (res *pssh.Result, err error) {
+	timeout := d.settings().exec.Timeout
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err = d.withSSHClient(func(c *ssh.Client) error {
+		d.log("Running %q", cmd)
+		res, err = pssh.Run(ctx, c, strings.TrimSpace(cmd))
+		if err == nil {
+			d.log("%q exited with code %d after %v", cmd, res.ExitCode, res.Duration)
+		}
+		return err
+	})
+	return
+}
+
+// ExecJob enqueues a job, which runs an allowed command. The job fails on
+// non-zero exit codes; the result is available via Job.ExecResult.
+func (d *Device) ExecJob(cmd string) (*Job, error) {
+	if !d.settings().exec.Allowed(cmd) {
+		return nil, ErrCommandNotAllowed(cmd)
+	}
+	return d.enqueue("exec", "executing", func() error {
+		res, err := d.Run(context.Background(), cmd)
+		if err != nil {
+			return err
+		}
+		job := d.CurrentJob()
+		job.setExecResult(res)
+		job.setResult(fmt.Sprintf("exit code %d", res.ExitCode))
+		return res.Err()
+	}), nil
+}
+
+// StartBulkExec runs an allowed command on all given devices in
+// background (see StartBulk). Devices without IP address are rejected.
+func (c *Configuration) StartBulkExec(cmd string, devices []*Device, opts BulkOptions) (*Bulk, error) {
+	if opts := c.settings().Exec; !opts.Allowed(cmd) {
+		return nil, ErrCommandNotAllowed(cmd)
+	}
+	for _, dev := range devices {
+		if dev.IPAddress() == "" {
+			return nil, fmt.Errorf("device %s has no unique IP address", dev.MacAddress)
+		}
+	}
+	return c.startBulk("exec", devices, opts, func(dev *Device) (*Job, error) {
+		return dev.ExecJob(cmd)
+	})
+}
+
+// ExecReport is the outcome of running a command on a single device.
+type ExecReport struct {
+	Device *Device
+	Result *pssh.Result
+	Err    error
+}
+
+// RunCommand executes any command on all given devices, at most
+// concurrency at a time, and returns the reports in the order of the
+// devices. Like Device.Run, the allowlist is not checked.
+func RunCommand(ctx context.Context, devices []*Device, cmd string, concurrency int) []*ExecReport {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	reports := make([]*ExecReport, len(devices))
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, dev := range devices {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, dev *Device) {
+			defer func() { <-slots; wg.Done() }()
+			res, err := dev.Run(ctx, cmd)
+			reports[i] = &ExecReport{Device: dev, Result: res, Err: err}
+		}(i, dev)
+	}
+	wg.Wait()
+	return reports
+}
@@ -0,0 +1,48 @@
+package provisioner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecAllowed(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := ExecOptions{Commands: []string{"ifconfig", "cat /proc/uptime"}}
+	assert.True(opts.Allowed("ifconfig"))
+	assert.True(opts.Allowed(" cat /proc/uptime\n"))
+	assert.False(opts.Allowed("cat /proc/uptime; reboot"))
+	assert.False(opts.Allowed("cat"))
+
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.exec = opts
+	_, err := dev.Exec(context.Background(), "reboot")
+	assert.Equal(ErrCommandNotAllowed("reboot"), err)
+}
+
+func TestRunCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	var devices []*Device
+	for _, mac := range []string{"00:11:22:aa:bb:01", "00:11:22:aa:bb:02", "00:11:22:aa:bb:03"} {
+		devices = append(devices, newDevice(&discovery.Device{MacAddress: mac}))
+	}
+
+	reports := RunCommand(context.Background(), devices, "uptime", 2)
+	if assert.Len(reports, 3) {
+		for i, r := range reports {
+			assert.Equal(devices[i], r.Device)
+			assert.Nil(r.Result)
+			assert.Error(r.Err) // no credentials
+		}
+	}
+
+	c := &Configuration{bulks: &bulkList{}, Settings: Settings{Exec: ExecOptions{Commands: []string{"uptime"}}}}
+	_, err := c.StartBulkExec("reboot", devices, BulkOptions{})
+	assert.Equal(ErrCommandNotAllowed("reboot"), err)
+	_, err = c.StartBulkExec("uptime", devices, BulkOptions{})
+	assert.EqualError(err, "device 00:11:22:aa:bb:01 has no unique IP address")
+}
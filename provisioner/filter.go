@@ -0,0 +1,68 @@
+package provisioner
+
+import (
+	"path"
+	"sort"
+)
+
+// DeviceFilter selects devices by their discovery information. Empty
+// fields match any device, the string fields are matched as shell
+// patterns (see path.Match), e.g. "NanoBeam*".
+type DeviceFilter struct {
+	MacAddresses []string `json:"mac_addresses" yaml:"mac_addresses"`
+	Hostname     string   `json:"hostname" yaml:"hostname"`
+	Model        string   `json:"model" yaml:"model"`
+	Platform     string   `json:"platform" yaml:"platform"`
+	Firmware     string   `json:"firmware" yaml:"firmware"`
+}
+
+// Empty is true, if the filter has no criteria (and hence matches all
+// devices).
+func (f *DeviceFilter) Empty() bool {
+	return len(f.MacAddresses) == 0 && f.Hostname == "" && f.Model == "" && f.Platform == "" && f.Firmware == ""
+}
+
+// Match checks whether the given device satisfies all filter criteria.
+func (f *DeviceFilter) Match(dev *Device) bool {
+	snap := dev.Snapshot()
+	if len(f.MacAddresses) > 0 {
+		found := false
+		for _, mac := range f.MacAddresses {
+			if sanitizeMac(mac) == sanitizeMac(snap.MacAddress) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return matchPattern(f.Hostname, snap.Hostname) &&
+		matchPattern(f.Model, snap.Model) &&
+		matchPattern(f.Platform, snap.Platform) &&
+		matchPattern(f.Firmware, snap.Firmware)
+}
+
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return ok && err == nil
+}
+
+// FilterDevices returns all discovered devices matching the filter,
+// ordered by MAC address. Devices without a unique IP address are left
+// out, since they can't be contacted.
+func (c *Configuration) FilterDevices(f *DeviceFilter) (list []*Device) {
+	for _, dev := range c.GetDevices() {
+		if dev.IPAddress() != "" && f.Match(dev) {
+			list = append(list, dev)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].MacAddress < list[j].MacAddress
+	})
+	return
+}
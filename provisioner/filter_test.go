@@ -0,0 +1,57 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Configuration{devices: &deviceCache{list: make(map[string]*Device), updated: time.Now()}}
+	for mac, ip := range map[string]string{
+		"00:11:22:aa:bb:03": "192.168.1.3",
+		"00:11:22:aa:bb:01": "192.168.1.1",
+		"00:11:22:aa:bb:02": "", // shared IP address
+	} {
+		dev := newDevice(&discovery.Device{MacAddress: mac, Model: "LiteBeam 5AC"})
+		dev.cfg.ipAddress = ip
+		c.devices.list[mac] = dev
+	}
+
+	var macs []string
+	for _, dev := range c.FilterDevices(&DeviceFilter{Model: "LiteBeam*"}) {
+		macs = append(macs, dev.MacAddress)
+	}
+	assert.Equal([]string{"00:11:22:aa:bb:01", "00:11:22:aa:bb:03"}, macs)
+	assert.Empty(c.FilterDevices(&DeviceFilter{Model: "NanoBeam*"}))
+}
+
+func TestFilterMatchDuringMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:01", Model: "LiteBeam 5AC"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dev.merge(&discovery.Device{
+				MacAddress:  "00:11:22:aa:bb:01",
+				Model:       "LiteBeam 5AC",
+				Firmware:    "v8.7.1",
+				IPAddresses: map[string][]string{"00:11:22:aa:bb:01": {"192.168.1.1"}},
+				LastSeenAt:  time.Now(),
+			})
+		}
+	}()
+
+	f := &DeviceFilter{MacAddresses: []string{"00:11:22:aa:bb:01"}, Model: "LiteBeam*"}
+	for i := 0; i < 100; i++ {
+		assert.True(f.Match(dev))
+		dev.RecentlySeen(lostAfter)
+	}
+	<-done
+}
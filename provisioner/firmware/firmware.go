@@ -0,0 +1,236 @@
+// Package firmware parses the header of Ubiquiti AirOS firmware images
+// (*.bin files), as produced by Ubiquiti's build system (or OpenWrt's
+// mkfwimage).
+//
+// An image consists of a header, followed by a number of partitions and
+// a trailing signature. All integers are big endian, all checksums are
+// CRC32 (IEEE):
+//
+//	header:    "UBNT" | version (256 bytes) | crc | pad
+//	partition: "PART" | name (16 bytes) | pad (12 bytes) | memaddr | index
+//	           | baseaddr | entryaddr | data_size | part_size
+//	           | data (data_size bytes) | crc | pad
+//	signature: "END." or "ENDS" | crc | pad
+package firmware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	magicHeader     = "UBNT"
+	magicHeaderOpen = "OPEN" // OpenWrt images
+	magicPart       = "PART"
+	magicEnd        = "END."
+	magicEndSigned  = "ENDS"
+
+	versionLength  = 256
+	partNameLength = 16
+)
+
+// Partition describes a single partition of an image.
+type Partition struct {
+	Name      string
+	Index     uint32
+	MemAddr   uint32
+	BaseAddr  uint32
+	EntryAddr uint32
+	DataSize  uint32
+	PartSize  uint32
+	CRC       uint32
+}
+
+// Image holds the meta data of a firmware image.
+type Image struct {
+	Version    string // e.g. "XC.qca955x.v8.1.4.34481.170608.1728"
+	Board      string // e.g. "XC"
+	Platform   string // e.g. "qca955x"
+	Partitions []Partition
+	Signed     bool
+	Size       int64
+}
+
+// Open reads and verifies the image file found at path.
+func Open(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img, nil
+}
+
+// Parse reads an image from r and verifies all checksums.
+func Parse(r io.Reader) (*Image, error) {
+	p := &parser{
+		r:   bufio.NewReader(r),
+		sum: crc32.NewIEEE(),
+	}
+	return p.parse()
+}
+
+// Compatible checks whether the image may be installed on a device
+// currently running the given firmware version (as reported by the
+// device, e.g. "XC.qca955x.v7.2.1.30741.160412.1342").
+func (img *Image) Compatible(firmware string) error {
+	board, platform := splitVersion(firmware)
+	if board != img.Board {
+		return fmt.Errorf("image is built for board %s, device has %s", img.Board, board)
+	}
+	if img.Platform != "" && platform != "" && img.Platform != platform {
+		return fmt.Errorf("image is built for platform %s, device has %s", img.Platform, platform)
+	}
+	return nil
+}
+
+// splitVersion extracts board and platform from a version string.
+func splitVersion(version string) (board, platform string) {
+	parts := strings.SplitN(version, ".", 3)
+	board = parts[0]
+	if len(parts) > 1 && !(len(parts[1]) > 1 && parts[1][0] == 'v' && parts[1][1] >= '0' && parts[1][1] <= '9') {
+		platform = parts[1]
+	}
+	return
+}
+
+type parser struct {
+	r   *bufio.Reader
+	sum hash.Hash32 // running checksum of the current section
+	all int64       // bytes read
+}
+
+func (p *parser) parse() (*Image, error) {
+	img := &Image{}
+
+	// header
+	magic, err := p.readString(4)
+	if err != nil {
+		return nil, err
+	}
+	if magic != magicHeader && magic != magicHeaderOpen {
+		return nil, fmt.Errorf("invalid magic %q, not a firmware image", magic)
+	}
+	if img.Version, err = p.readString(versionLength); err != nil {
+		return nil, err
+	}
+	if err = p.checkCRC("header"); err != nil {
+		return nil, err
+	}
+	img.Board, img.Platform = splitVersion(img.Version)
+
+	// partitions and signature
+	for {
+		p.sum.Reset()
+		if magic, err = p.readString(4); err != nil {
+			return nil, err
+		}
+
+		switch magic {
+		case magicPart:
+			part, err := p.readPartition()
+			if err != nil {
+				return nil, err
+			}
+			img.Partitions = append(img.Partitions, *part)
+		case magicEnd, magicEndSigned:
+			img.Signed = magic == magicEndSigned
+			// the signature CRC covers the whole file, we've already
+			// verified each section individually
+			if _, err = p.read(8); err != nil { // crc + padding
+				return nil, err
+			}
+			img.Size = p.all
+			return img, nil
+		default:
+			return nil, fmt.Errorf("unexpected section %q at offset %d", magic, p.all-4)
+		}
+	}
+}
+
+func (p *parser) readPartition() (*Partition, error) {
+	name, err := p.readString(partNameLength)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.read(12); err != nil {
+		return nil, err
+	}
+
+	part := &Partition{Name: name}
+	for _, field := range []*uint32{&part.MemAddr, &part.Index, &part.BaseAddr, &part.EntryAddr, &part.DataSize, &part.PartSize} {
+		if *field, err = p.readUint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	if n, err := io.CopyN(p.sum, p.r, int64(part.DataSize)); err != nil {
+		return nil, fmt.Errorf("partition %s truncated (%d of %d bytes): %v", name, n, part.DataSize, err)
+	}
+	p.all += int64(part.DataSize)
+
+	part.CRC = p.sum.Sum32()
+	if err = p.checkCRC("partition " + name); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// checkCRC reads the CRC and padding and compares the CRC with the
+// checksum of the current section.
+func (p *parser) checkCRC(section string) error {
+	computed := p.sum.Sum32()
+	crc, err := p.readUint32()
+	if err != nil {
+		return err
+	}
+	if _, err = p.readUint32(); err != nil { // padding
+		return err
+	}
+	if crc != computed {
+		return fmt.Errorf("%s: CRC mismatch (expected %08x, got %08x)", section, crc, computed)
+	}
+	return nil
+}
+
+// read reads exactly n bytes and adds them to the running checksum.
+func (p *parser) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, fmt.Errorf("unexpected end of image at offset %d", p.all)
+	}
+	p.all += int64(n)
+	p.sum.Write(buf)
+	return buf, nil
+}
+
+func (p *parser) readString(n int) (string, error) {
+	buf, err := p.read(n)
+	if err != nil {
+		return "", err
+	}
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf), nil
+}
+
+func (p *parser) readUint32() (uint32, error) {
+	buf, err := p.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf), nil
+}
@@ -0,0 +1,111 @@
+package firmware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildImage assembles a minimal image with the given partitions.
+func buildImage(version string, end string, parts map[string][]byte, order ...string) []byte {
+	var img bytes.Buffer
+
+	section := func(b []byte) {
+		img.Write(b)
+		binary.Write(&img, binary.BigEndian, crc32.ChecksumIEEE(b))
+		binary.Write(&img, binary.BigEndian, uint32(0))
+	}
+
+	hdr := make([]byte, 4+versionLength)
+	copy(hdr, magicHeader)
+	copy(hdr[4:], version)
+	section(hdr)
+
+	for i, name := range order {
+		data := parts[name]
+		var p bytes.Buffer
+		p.WriteString(magicPart)
+		n := make([]byte, partNameLength+12)
+		copy(n, name)
+		p.Write(n)
+		for _, v := range []uint32{0x80000000, uint32(i + 1), 0x9f050000, 0x80002000, uint32(len(data)), 0x00100000} {
+			binary.Write(&p, binary.BigEndian, v)
+		}
+		p.Write(data)
+		section(p.Bytes())
+	}
+
+	sig := []byte(end)
+	sig = append(sig, make([]byte, 8)...)
+	binary.BigEndian.PutUint32(sig[4:], crc32.ChecksumIEEE(img.Bytes()))
+	img.Write(sig)
+	return img.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := buildImage("XC.qca955x.v8.1.4.34481.170608.1728", magicEndSigned, map[string][]byte{
+		"kernel": bytes.Repeat([]byte{0xaa}, 1000),
+		"rootfs": bytes.Repeat([]byte{0x55}, 3000),
+	}, "kernel", "rootfs")
+
+	img, err := Parse(bytes.NewReader(raw))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("XC.qca955x.v8.1.4.34481.170608.1728", img.Version)
+	assert.Equal("XC", img.Board)
+	assert.Equal("qca955x", img.Platform)
+	assert.True(img.Signed)
+	assert.EqualValues(len(raw), img.Size)
+	if assert.Len(img.Partitions, 2) {
+		assert.Equal("kernel", img.Partitions[0].Name)
+		assert.EqualValues(1000, img.Partitions[0].DataSize)
+		assert.Equal("rootfs", img.Partitions[1].Name)
+		assert.EqualValues(2, img.Partitions[1].Index)
+	}
+
+	assert.NoError(img.Compatible("XC.qca955x.v7.2.1.30741.160412.1342"))
+	assert.Error(img.Compatible("XW.ar934x.v6.0.4.30805.170505.1510"))
+	assert.Error(img.Compatible("XC.qca956x.v8.0.2.33352.170327.1907"))
+}
+
+func TestParseErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := buildImage("XC.qca955x.v8.1.4.34481.170608.1728", magicEnd, map[string][]byte{
+		"kernel": bytes.Repeat([]byte{0xaa}, 1000),
+	}, "kernel")
+
+	_, err := Parse(bytes.NewReader([]byte("hello world")))
+	assert.EqualError(err, `invalid magic "hell", not a firmware image`)
+
+	_, err = Parse(bytes.NewReader(raw[:1000]))
+	assert.Error(err)
+
+	corrupt := append([]byte(nil), raw...)
+	corrupt[len(corrupt)-100] ^= 0xff
+	_, err = Parse(bytes.NewReader(corrupt))
+	assert.Contains(err.Error(), "partition kernel: CRC mismatch")
+
+	corrupt = append([]byte(nil), raw...)
+	corrupt[10] = 'X'
+	_, err = Parse(bytes.NewReader(corrupt))
+	assert.Contains(err.Error(), "header: CRC mismatch")
+}
+
+func TestSplitVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	board, platform := splitVersion("XC.v8.1.4.34481.170608.1728")
+	assert.Equal("XC", board)
+	assert.Equal("", platform)
+
+	board, platform = splitVersion("XM.ar7240.v5.6.9.29546.160819.1157")
+	assert.Equal("XM", board)
+	assert.Equal("ar7240", platform)
+}
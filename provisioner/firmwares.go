@@ -0,0 +1,207 @@
+package provisioner
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digineo/goldflags"
+	"github.com/digineo/ubnt-tools/provisioner/firmware"
+)
+
+const (
+	// firmwareManifest is the name of a file in the firmware_directory,
+	// listing the SHA256 checksums of the images (as created by
+	// "sha256sum *.bin > SHA256SUMS").
+	firmwareManifest = "SHA256SUMS"
+
+	// firmwareSidecarExt is appended to an image name to find a file
+	// containing only the checksum of that image.
+	firmwareSidecarExt = ".sha256"
+)
+
+// FirmwareFile describes a file in the firmware_directory. If the file
+// isn't a valid firmware image, or its checksum is unknown or doesn't
+// match, Err is set.
+type FirmwareFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	SHA256  string
+	MD5     string
+	Image   *firmware.Image
+	Err     error
+
+	stamp string // identifies the state of image and checksum files
+}
+
+// firmwareCache remembers the meta data of firmware images, until the
+// file (or its checksum) is modified.
+type firmwareCache struct {
+	files map[string]*FirmwareFile
+	sync.Mutex
+}
+
+func (c *firmwareCache) lookup(dir, name string) *FirmwareFile {
+	if c == nil {
+		return inspectFirmware(filepath.Join(dir, name))
+	}
+
+	path := filepath.Join(dir, name)
+	stamp, err := firmwareStamp(path)
+	if err != nil {
+		return &FirmwareFile{Name: name, Err: err}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if f, ok := c.files[name]; ok && f.stamp == stamp {
+		return f
+	}
+	f := inspectFirmware(path)
+	f.stamp = stamp
+	c.files[name] = f
+	return f
+}
+
+// firmwareStamp summarizes size and modification times of an image and
+// the files containing its checksum.
+func firmwareStamp(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	stamp := fmt.Sprintf("%d/%d", fi.Size(), fi.ModTime().UnixNano())
+
+	for _, sums := range []string{filepath.Join(filepath.Dir(path), firmwareManifest), path + firmwareSidecarExt} {
+		if fi, err := os.Stat(sums); err == nil {
+			stamp += fmt.Sprintf("/%d", fi.ModTime().UnixNano())
+		} else {
+			stamp += "/-"
+		}
+	}
+	return stamp, nil
+}
+
+// inspectFirmware computes the checksums of an image, compares them with
+// the expected values and parses the image header.
+func inspectFirmware(path string) *FirmwareFile {
+	f := &FirmwareFile{Name: filepath.Base(path)}
+
+	file, err := os.Open(path)
+	if err != nil {
+		f.Err = err
+		return f
+	}
+	defer file.Close()
+
+	if fi, err := file.Stat(); err == nil {
+		f.Size = fi.Size()
+		f.ModTime = fi.ModTime()
+	}
+
+	sha, md := sha256.New(), md5.New()
+	if _, err = io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		f.Err = err
+		return f
+	}
+	f.SHA256 = hex.EncodeToString(sha.Sum(nil))
+	f.MD5 = hex.EncodeToString(md.Sum(nil))
+
+	expected, err := expectedChecksum(path)
+	if err != nil {
+		f.Err = err
+		return f
+	}
+	if expected != f.SHA256 {
+		f.Err = fmt.Errorf("checksum mismatch (expected %s, got %s)", expected, f.SHA256)
+		return f
+	}
+
+	f.Image, f.Err = firmware.Open(path)
+	return f
+}
+
+// expectedChecksum looks up the SHA256 checksum of an image in the
+// sidecar file or manifest.
+func expectedChecksum(path string) (string, error) {
+	name := filepath.Base(path)
+
+	if content, err := ioutil.ReadFile(path + firmwareSidecarExt); err == nil {
+		if fields := strings.Fields(string(content)); len(fields) > 0 {
+			return strings.ToLower(fields[0]), nil
+		}
+		return "", fmt.Errorf("%s%s is empty", name, firmwareSidecarExt)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	manifest, err := os.Open(filepath.Join(filepath.Dir(path), firmwareManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no checksum found (neither %s nor %s%s exist)", firmwareManifest, name, firmwareSidecarExt)
+		}
+		return "", err
+	}
+	defer manifest.Close()
+
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		// "<checksum>  <name>" (text mode) or "<checksum> *<name>" (binary)
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(fields) == 2 && strings.TrimLeft(fields[1], " *") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("image not listed in %s", firmwareManifest)
+}
+
+// checkFirmwares inspects the images of the safe_upgrade_paths, so that
+// their checksums are cached before the device cache needs them. Invalid
+// images are excluded from upgrade plans (see image()), hence they are
+// reported here.
+func checkFirmwares(s *Settings, events *eventBus) {
+	for image := range s.SafeUpgradePaths {
+		if f := s.firmwares.lookup(s.FirmwareDirectory, image); f.Err != nil {
+			msg := fmt.Sprintf("Firmware image %s is invalid, upgrades to it are disabled: %v", image, f.Err)
+			log.Printf("[firmware] %s", msg)
+			events.publish(&Event{Type: EventAlert, Message: msg})
+		}
+	}
+}
+
+// image returns the meta data of a valid firmware image (or nil). Must
+// be called while holding the device cache lock.
+func (c *Configuration) image(name string) *firmware.Image {
+	if f := c.firmwares.lookup(c.FirmwareDirectory, name); f.Err == nil {
+		return f.Image
+	}
+	return nil
+}
+
+// GetFirmwares inspects all files in the firmware_directory (except for
+// checksum files).
+func (c *Configuration) GetFirmwares() (list []*FirmwareFile) {
+	s := c.settings()
+	for _, name := range goldflags.ReadDir(s.FirmwareDirectory) {
+		if name == firmwareManifest || strings.HasSuffix(name, firmwareSidecarExt) {
+			continue
+		}
+		list = append(list, s.firmwares.lookup(s.FirmwareDirectory, name))
+	}
+	return
+}
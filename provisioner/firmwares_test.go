@@ -0,0 +1,117 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectedChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	write := func(name, content string) {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	_, err := expectedChecksum(filepath.Join(dir, "a.bin"))
+	assert.EqualError(err, "no checksum found (neither SHA256SUMS nor a.bin.sha256 exist)")
+
+	write(firmwareManifest, "AAAA  a.bin\nbbbb *b.bin\n")
+	sum, err := expectedChecksum(filepath.Join(dir, "a.bin"))
+	assert.NoError(err)
+	assert.Equal("aaaa", sum)
+
+	sum, err = expectedChecksum(filepath.Join(dir, "b.bin"))
+	assert.NoError(err)
+	assert.Equal("bbbb", sum)
+
+	_, err = expectedChecksum(filepath.Join(dir, "c.bin"))
+	assert.EqualError(err, "image not listed in SHA256SUMS")
+
+	write("a.bin"+firmwareSidecarExt, "cccc  a.bin\n")
+	sum, err = expectedChecksum(filepath.Join(dir, "a.bin"))
+	assert.NoError(err)
+	assert.Equal("cccc", sum)
+}
+
+func TestInspectFirmwareChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "a.bin")
+	assert.NoError(ioutil.WriteFile(path, []byte("not an image"), 0644))
+
+	// checksum of some other file
+	const sum = "d0b7b9a2ab5e4ec8a4d4a5de5cfb5aa6dab0fb19bcf6ba2c4b7ab7a1ba0e6f4e"
+	assert.NoError(ioutil.WriteFile(path+firmwareSidecarExt, []byte(sum), 0644))
+
+	f := inspectFirmware(path)
+	assert.Contains(f.Err.Error(), "checksum mismatch")
+
+	assert.NoError(ioutil.WriteFile(path+firmwareSidecarExt, []byte(f.SHA256), 0644))
+	f = inspectFirmware(path)
+	assert.Contains(f.Err.Error(), "not a firmware image")
+	assert.Nil(f.Image)
+}
+
+func TestFirmwareCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.bin")
+	assert.NoError(ioutil.WriteFile(path, []byte("not an image"), 0644))
+
+	cache := &firmwareCache{files: make(map[string]*FirmwareFile)}
+	f := cache.lookup(dir, "a.bin")
+	assert.Contains(f.Err.Error(), "no checksum found")
+	assert.True(f == cache.lookup(dir, "a.bin"), "expected cached entry")
+
+	// changing the image invalidates the entry
+	assert.NoError(ioutil.WriteFile(path, []byte("still not an image"), 0644))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(os.Chtimes(path, future, future))
+	g := cache.lookup(dir, "a.bin")
+	assert.False(f == g, "expected fresh entry")
+	assert.NotEqual(f.SHA256, g.SHA256)
+}
+
+func TestLoadConfigInvalidFirmware(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(os.Mkdir(filepath.Join(dir, "configs"), 0755))
+	assert.NoError(os.Mkdir(filepath.Join(dir, "firmwares"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "firmwares", "XC.v8.1.4.bin"), []byte("corrupt"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte(`
+config_directory: ./configs
+firmware_directory: ./firmwares
+safe_upgrade_paths:
+  "XC.v8.1.4.bin": ["XC.qca955x.v7.2.4"]
+interfaces: [eth0]
+web: {port: 8080}
+`), 0644))
+
+	c, errs := LoadConfig(filepath.Join(dir, "config.yml"))
+	if !assert.Empty(errs) {
+		return
+	}
+	assert.Nil(c.image("XC.v8.1.4.bin"))
+	if f := c.firmwares.files["XC.v8.1.4.bin"]; assert.NotNil(f, "image should be inspected on startup") {
+		assert.Error(f.Err)
+	}
+
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+	assert.Empty(c.Reload())
+	e := <-events
+	assert.Equal(EventAlert, e.Type)
+	assert.Contains(e.Message, "XC.v8.1.4.bin")
+	assert.Equal(EventConfigReload, (<-events).Type)
+}
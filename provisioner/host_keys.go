@@ -0,0 +1,212 @@
+package provisioner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/digineo/goldflags"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host key verification modes.
+const (
+	HostKeysTOFU   = "tofu"   // trust (and remember) unknown keys on first use
+	HostKeysStrict = "strict" // only accept known keys
+	HostKeysOff    = "off"    // accept any key
+)
+
+// HostKeyOptions configures the verification of SSH host keys.
+type HostKeyOptions struct {
+	Mode string `yaml:"mode"`
+	File string `yaml:"file"`
+}
+
+// HostKey is a known SSH host key.
+type HostKey struct {
+	MacAddress  string
+	Type        string
+	Fingerprint string // SHA256
+}
+
+// HostKeyError is returned when connecting to a device, whose host key
+// has changed, or is unknown in strict mode. Known is nil in the latter
+// case.
+type HostKeyError struct {
+	MacAddress string
+	Known      ssh.PublicKey
+	Got        ssh.PublicKey
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Known == nil {
+		return fmt.Sprintf("unknown host key %s for %s (host_keys.mode is strict)", ssh.FingerprintSHA256(e.Got), e.MacAddress)
+	}
+	return fmt.Sprintf("host key of %s has changed (known %s, got %s). If the device has been reset, forget the old key and try again",
+		e.MacAddress, ssh.FingerprintSHA256(e.Known), ssh.FingerprintSHA256(e.Got))
+}
+
+// hostKeyStore maps device MAC addresses to SSH host keys. IP addresses
+// aren't suitable, since all devices share the same factory default.
+//
+// The file contains one key per line, as "aabbccddeeff <authorized_keys
+// formatted key>".
+type hostKeyStore struct {
+	mode string
+	path string
+	keys map[string]ssh.PublicKey // sanitized MAC -> key
+	mtx  sync.Mutex
+}
+
+func loadHostKeys(opts HostKeyOptions, base string) (*hostKeyStore, error) {
+	s := &hostKeyStore{mode: opts.Mode, keys: make(map[string]ssh.PublicKey)}
+	switch s.mode {
+	case "":
+		s.mode = HostKeysTOFU
+	case HostKeysTOFU, HostKeysStrict, HostKeysOff:
+	default:
+		return nil, fmt.Errorf("invalid host_keys.mode %q", opts.Mode)
+	}
+	if s.mode == HostKeysOff {
+		return s, nil
+	}
+
+	file := opts.File
+	if file == "" {
+		file = "./known_hosts"
+	}
+	path, err := goldflags.ExpandPath(file, base)
+	if err != nil {
+		return nil, fmt.Errorf("host_keys.file: %v", err)
+	}
+	s.path = path
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid line", s.path, n)
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", s.path, n, err)
+		}
+		s.keys[sanitizeMac(fields[0])] = key
+	}
+	return s, scanner.Err()
+}
+
+// save writes all keys to the file. The caller must hold the lock.
+func (s *hostKeyStore) save() error {
+	macs := make([]string, 0, len(s.keys))
+	for mac := range s.keys {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+
+	var buf bytes.Buffer
+	for _, mac := range macs {
+		fmt.Fprintf(&buf, "%s %s", mac, ssh.MarshalAuthorizedKey(s.keys[mac]))
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// callback returns a HostKeyCallback for the device with the given MAC
+// address.
+func (s *hostKeyStore) callback(mac string) ssh.HostKeyCallback {
+	if s == nil || s.mode == HostKeysOff {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	mac = sanitizeMac(mac)
+
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		known, ok := s.keys[mac]
+		switch {
+		case ok && bytes.Equal(known.Marshal(), key.Marshal()):
+			return nil
+		case ok, s.mode == HostKeysStrict:
+			return &HostKeyError{MacAddress: mac, Known: known, Got: key}
+		}
+
+		s.keys[mac] = key
+		return s.save()
+	}
+}
+
+func makeHostKey(mac string, key ssh.PublicKey) *HostKey {
+	return &HostKey{MacAddress: mac, Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)}
+}
+
+// KnownHostKeys lists all known host keys, sorted by MAC address.
+func (c *Configuration) KnownHostKeys() (list []*HostKey) {
+	s := c.settings().hostKeys
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for mac, key := range s.keys {
+		list = append(list, makeHostKey(mac, key))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MacAddress < list[j].MacAddress })
+	return
+}
+
+// HostKey returns the known host key of the device (or nil).
+func (d *Device) HostKey() *HostKey {
+	s := d.settings().hostKeys
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	mac := sanitizeMac(d.MacAddress)
+	if key, ok := s.keys[mac]; ok {
+		return makeHostKey(mac, key)
+	}
+	return nil
+}
+
+// ForgetHostKey removes the known host key of the device, i.e. after it
+// has been reset to factory defaults.
+func (d *Device) ForgetHostKey() error {
+	s := d.settings().hostKeys
+	if s == nil || s.mode == HostKeysOff {
+		return fmt.Errorf("host key verification is disabled")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	mac := sanitizeMac(d.MacAddress)
+	if _, ok := s.keys[mac]; !ok {
+		return fmt.Errorf("no host key known for %s", d.MacAddress)
+	}
+	delete(s.keys, mac)
+	d.log("Host key forgotten")
+	return s.save()
+}
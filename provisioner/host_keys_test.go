@@ -0,0 +1,64 @@
+package provisioner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	key1, key2 := newTestHostKey(t), newTestHostKey(t)
+
+	s, err := loadHostKeys(HostKeyOptions{}, dir)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(HostKeysTOFU, s.mode)
+	assert.Equal(filepath.Join(dir, "known_hosts"), s.path)
+
+	cb := s.callback("00:11:22:AA:BB:CC")
+	assert.NoError(cb("", nil, key1)) // first use
+	assert.NoError(cb("", nil, key1))
+	assert.Contains(cb("", nil, key2).Error(), "host key of 001122aabbcc has changed")
+
+	// reload from file, strict mode
+	s, err = loadHostKeys(HostKeyOptions{Mode: HostKeysStrict, File: filepath.Join(dir, "known_hosts")}, "/")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(s.callback("00:11:22:aa:bb:cc")("", nil, key1))
+
+	// relative to the config file
+	s, err = loadHostKeys(HostKeyOptions{Mode: HostKeysStrict, File: "./known_hosts"}, dir)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(s.callback("00:11:22:aa:bb:cc")("", nil, key1))
+	assert.Contains(s.callback("00:11:22:aa:bb:cd")("", nil, key2).Error(), "unknown host key")
+
+	_, err = loadHostKeys(HostKeyOptions{Mode: "yolo"}, dir)
+	assert.EqualError(err, `invalid host_keys.mode "yolo"`)
+
+	var none *hostKeyStore
+	assert.NoError(none.callback("00:11:22:aa:bb:cc")("", nil, key2))
+}
@@ -0,0 +1,284 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+)
+
+// JobState describes the life cycle of a Job.
+type JobState string
+
+// Job states. A Job starts as JobQueued, transitions to JobRunning once
+// the device is ready, and ends in one of the remaining states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// jobHistorySize limits the number of finished jobs we remember.
+const jobHistorySize = 250
+
+// Job tracks a single operation (provisioning, upgrade, reboot) on a
+// single device.
+type Job struct {
+	ID         uint64
+	Type       string
+	MacAddress string
+	CreatedAt  time.Time
+
+	state      JobState
+	startedAt  time.Time
+	finishedAt time.Time
+	log        []string
+	err        error
+	result     string
+	progress   *pssh.Progress
+	exec       *pssh.Result
+
+	status string       // busy message for the device
+	run    func() error // the actual work
+	events *eventBus
+	done   chan struct{}
+	mtx    sync.RWMutex
+}
+
+// State returns the current state.
+func (j *Job) State() JobState {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.state
+}
+
+// Finished indicates whether the job has reached a final state.
+func (j *Job) Finished() bool {
+	switch j.State() {
+	case JobSucceeded, JobFailed, JobCancelled:
+		return true
+	}
+	return false
+}
+
+// StartedAt returns the time the job has been started (or the zero time,
+// if it is still queued).
+func (j *Job) StartedAt() time.Time {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.startedAt
+}
+
+// FinishedAt returns the time the job has been finished (or the zero
+// time, if it is still queued or running).
+func (j *Job) FinishedAt() time.Time {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.finishedAt
+}
+
+// Log returns a copy of the captured log lines.
+func (j *Job) Log() []string {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return append([]string(nil), j.log...)
+}
+
+// Err returns the error which caused the job to fail (nil otherwise).
+func (j *Job) Err() error {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.err
+}
+
+// Result returns a job specific summary of the outcome (e.g. for
+// upgrades, one of the Upgrade* constants). It may be empty.
+func (j *Job) Result() string {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.result
+}
+
+func (j *Job) setResult(result string) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.result = result
+}
+
+// Progress returns the state of the last file transfer (e.g. a firmware
+// upload), or nil if there was none.
+func (j *Job) Progress() *pssh.Progress {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.progress
+}
+
+func (j *Job) setProgress(p pssh.Progress) {
+	j.mtx.Lock()
+	j.progress = &p
+	j.mtx.Unlock()
+
+	j.events.publish(&Event{Type: EventJobProgress, Job: j})
+}
+
+// ExecResult returns the outcome of an "exec" job (or nil).
+func (j *Job) ExecResult() *pssh.Result {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+	return j.exec
+}
+
+func (j *Job) setExecResult(res *pssh.Result) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.exec = res
+}
+
+// Done returns a channel, which is closed when the job has finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait blocks until the job has finished and returns its error.
+func (j *Job) Wait() error {
+	<-j.done
+	return j.Err()
+}
+
+// Cancel aborts a queued job. Jobs already running can't be cancelled.
+func (j *Job) Cancel() error {
+	j.mtx.Lock()
+	if j.state != JobQueued {
+		j.mtx.Unlock()
+		return fmt.Errorf("job %d is %s and cannot be cancelled", j.ID, j.state)
+	}
+	j.state = JobCancelled
+	j.finishedAt = time.Now()
+	close(j.done)
+	j.mtx.Unlock()
+
+	j.events.publish(&Event{Type: EventJob, Job: j})
+	return nil
+}
+
+func (j *Job) logf(message string, v ...interface{}) {
+	msg := fmt.Sprintf(message, v...)
+
+	j.mtx.Lock()
+	j.log = append(j.log, msg)
+	j.mtx.Unlock()
+
+	j.events.publish(&Event{Type: EventJobLog, Job: j, Message: msg})
+}
+
+// start transitions a queued job into the running state. It returns
+// false, if the job was cancelled in the meantime.
+func (j *Job) start() bool {
+	j.mtx.Lock()
+	if j.state != JobQueued {
+		j.mtx.Unlock()
+		return false
+	}
+	j.state = JobRunning
+	j.startedAt = time.Now()
+	j.mtx.Unlock()
+
+	j.events.publish(&Event{Type: EventJob, Job: j})
+	return true
+}
+
+func (j *Job) finish(err error) {
+	j.mtx.Lock()
+	if err != nil {
+		j.state = JobFailed
+		j.err = err
+	} else {
+		j.state = JobSucceeded
+	}
+	j.finishedAt = time.Now()
+	close(j.done)
+	j.mtx.Unlock()
+
+	j.events.publish(&Event{Type: EventJob, Job: j})
+}
+
+type jobList struct {
+	list   []*Job // ordered by ID
+	lastID uint64
+	events *eventBus
+	sync.RWMutex
+}
+
+func (l *jobList) create(typ, mac string) *Job {
+	l.Lock()
+	defer l.Unlock()
+
+	l.lastID++
+	job := &Job{
+		ID:         l.lastID,
+		Type:       typ,
+		MacAddress: mac,
+		CreatedAt:  time.Now(),
+		state:      JobQueued,
+		events:     l.events,
+		done:       make(chan struct{}),
+	}
+	l.list = append(l.list, job)
+	l.expire()
+	l.events.publish(&Event{Type: EventJob, Job: job})
+	return job
+}
+
+// expire removes the oldest finished jobs, if we remember too many.
+func (l *jobList) expire() {
+	excess := len(l.list) - jobHistorySize
+	if excess <= 0 {
+		return
+	}
+
+	kept := l.list[:0]
+	for _, job := range l.list {
+		if excess > 0 && job.Finished() {
+			excess--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	for i := len(kept); i < len(l.list); i++ {
+		l.list[i] = nil
+	}
+	l.list = kept
+}
+
+func (l *jobList) find(id uint64) *Job {
+	l.RLock()
+	defer l.RUnlock()
+
+	i := sort.Search(len(l.list), func(i int) bool { return l.list[i].ID >= id })
+	if i < len(l.list) && l.list[i].ID == id {
+		return l.list[i]
+	}
+	return nil
+}
+
+// GetJobs returns all known jobs, newest first.
+func (c *Configuration) GetJobs() (list []*Job) {
+	c.jobs.RLock()
+	defer c.jobs.RUnlock()
+
+	list = make([]*Job, 0, len(c.jobs.list))
+	for i := len(c.jobs.list) - 1; i >= 0; i-- {
+		list = append(list, c.jobs.list[i])
+	}
+	return
+}
+
+// FindJob searches the list of known jobs and returns a pointer to it
+// (or nil, if we can't find it).
+func (c *Configuration) FindJob(id uint64) *Job {
+	return c.jobs.find(id)
+}
@@ -0,0 +1,53 @@
+package provisioner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobLifeCycle(t *testing.T) {
+	assert := assert.New(t)
+	jobs := &jobList{}
+
+	ok := jobs.create("reboot", "00:11:22:33:44:55")
+	assert.EqualValues(1, ok.ID)
+	assert.Equal(JobQueued, ok.State())
+	assert.True(ok.start())
+	assert.Equal(JobRunning, ok.State())
+	assert.NotNil(ok.Cancel())
+	ok.finish(nil)
+	assert.Equal(JobSucceeded, ok.State())
+	assert.Nil(ok.Wait())
+
+	failed := jobs.create("upgrade", "00:11:22:33:44:55")
+	failed.start()
+	failed.finish(errors.New("boom"))
+	assert.Equal(JobFailed, failed.State())
+	assert.EqualError(failed.Wait(), "boom")
+
+	cancelled := jobs.create("provision", "00:11:22:33:44:55")
+	assert.Nil(cancelled.Cancel())
+	assert.Equal(JobCancelled, cancelled.State())
+	assert.False(cancelled.start())
+
+	assert.Equal(failed, jobs.find(2))
+	assert.Nil(jobs.find(4))
+}
+
+func TestJobListExpire(t *testing.T) {
+	assert := assert.New(t)
+	jobs := &jobList{}
+
+	running := jobs.create("upgrade", "00:11:22:33:44:55")
+	running.start()
+	for i := 0; i < jobHistorySize; i++ {
+		jobs.create("reboot", "00:11:22:33:44:55").Cancel()
+	}
+
+	assert.Len(jobs.list, jobHistorySize)
+	assert.Equal(running, jobs.find(running.ID))
+	assert.Nil(jobs.find(2))
+	assert.NotNil(jobs.find(jobHistorySize + 1))
+}
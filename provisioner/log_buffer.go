@@ -0,0 +1,85 @@
+package provisioner
+
+import (
+	"sync"
+	"time"
+)
+
+// deviceLogSize limits the number of log lines we keep per device.
+const deviceLogSize = 500
+
+// LogLine is a single, timestamped log message.
+type LogLine struct {
+	Time    time.Time
+	Message string
+}
+
+// logBuffer is a bounded ring buffer of log lines. Subscribers are
+// notified about new lines as they arrive.
+type logBuffer struct {
+	lines       []LogLine
+	next        int // index of the next write
+	full        bool
+	subscribers map[chan LogLine]struct{}
+	sync.RWMutex
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{
+		lines:       make([]LogLine, size),
+		subscribers: make(map[chan LogLine]struct{}),
+	}
+}
+
+func (b *logBuffer) append(msg string) {
+	line := LogLine{Time: time.Now(), Message: msg}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- line:
+		default: // slow reader, drop line
+		}
+	}
+}
+
+// list returns a copy of the buffered lines, oldest first.
+func (b *logBuffer) list() []LogLine {
+	b.RLock()
+	defer b.RUnlock()
+	return b.copyLines()
+}
+
+func (b *logBuffer) copyLines() []LogLine {
+	if !b.full {
+		return append([]LogLine(nil), b.lines[:b.next]...)
+	}
+	list := make([]LogLine, 0, len(b.lines))
+	list = append(list, b.lines[b.next:]...)
+	return append(list, b.lines[:b.next]...)
+}
+
+// subscribe returns the buffered lines and a channel receiving all
+// future lines. Call the returned function to unsubscribe.
+func (b *logBuffer) subscribe() ([]LogLine, <-chan LogLine, func()) {
+	ch := make(chan LogLine, 64)
+
+	b.Lock()
+	history := b.copyLines()
+	b.subscribers[ch] = struct{}{}
+	b.Unlock()
+
+	return history, ch, func() {
+		b.Lock()
+		delete(b.subscribers, ch)
+		b.Unlock()
+	}
+}
@@ -0,0 +1,39 @@
+package provisioner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func messages(lines []LogLine) (list []string) {
+	for _, l := range lines {
+		list = append(list, l.Message)
+	}
+	return
+}
+
+func TestLogBuffer(t *testing.T) {
+	assert := assert.New(t)
+	b := newLogBuffer(3)
+
+	assert.Empty(b.list())
+	b.append("a")
+	b.append("b")
+	assert.Equal([]string{"a", "b"}, messages(b.list()))
+
+	for i := 0; i < 4; i++ {
+		b.append(fmt.Sprintf("%d", i))
+	}
+	assert.Equal([]string{"1", "2", "3"}, messages(b.list()))
+
+	history, lines, unsubscribe := b.subscribe()
+	assert.Len(history, 3)
+	b.append("x")
+	assert.Equal("x", (<-lines).Message)
+
+	unsubscribe()
+	b.append("y")
+	assert.Len(lines, 0)
+}
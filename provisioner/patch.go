@@ -0,0 +1,157 @@
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConfigPatch describes changes to the running configuration of a device.
+// Keys in Delete ending with ".*" remove a whole section (e.g.
+// "snmp.community.*"). If Reboot is false, the changes are saved to flash,
+// but only become active after the next reboot.
+type ConfigPatch struct {
+	Set    map[string]string `json:"set"`
+	Delete []string          `json:"delete"`
+	Reboot bool              `json:"reboot"`
+}
+
+// Validate checks the patch for obvious mistakes.
+func (p *ConfigPatch) Validate() error {
+	if len(p.Set) == 0 && len(p.Delete) == 0 {
+		return fmt.Errorf("patch is empty")
+	}
+	for key, value := range p.Set {
+		if key == "" || strings.ContainsAny(key, "=\n") {
+			return fmt.Errorf("invalid key %q", key)
+		}
+		if strings.Contains(value, "\n") {
+			return fmt.Errorf("invalid value for key %s", key)
+		}
+	}
+	return nil
+}
+
+// Apply modifies cfg. Deletions are applied first.
+func (p *ConfigPatch) Apply(cfg *syscfg.Config) {
+	for _, key := range p.Delete {
+		if strings.HasSuffix(key, ".*") {
+			cfg.DeleteSection(strings.TrimSuffix(key, ".*"))
+		} else {
+			cfg.Delete(key)
+		}
+	}
+
+	keys := make([]string, 0, len(p.Set))
+	for key := range p.Set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cfg.Set(key, p.Set[key])
+	}
+}
+
+// readConfig fetches the running configuration.
+func (d *Device) readConfig(c *ssh.Client) (*syscfg.Config, error) {
+	var buf bytes.Buffer
+	if _, err := pssh.Download(c, remoteConfigPath, &buf, nil); err != nil {
+		return nil, fmt.Errorf("Could not read configuration: %v", err)
+	}
+	return syscfg.Parse(&buf)
+}
+
+// PatchDiff fetches the running configuration and returns the changes
+// the patch would apply (dry-run).
+func (d *Device) PatchDiff(p *ConfigPatch) (diff *syscfg.Diff, err error) {
+	err = d.withSSHClient(func(c *ssh.Client) error {
+		running, err := d.readConfig(c)
+		if err != nil {
+			return err
+		}
+		patched, _ := syscfg.ParseString(running.String())
+		p.Apply(patched)
+		diff = syscfg.Compare(running, patched)
+		return nil
+	})
+	return
+}
+
+// Patch enqueues a job, which applies the patch to the running
+// configuration and saves it.
+func (d *Device) Patch(p *ConfigPatch) (*Job, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return d.enqueue("patch", "patching", func() error {
+		return d.withSSHClient(func(c *ssh.Client) error {
+			running, err := d.readConfig(c)
+			if err != nil {
+				return err
+			}
+			content := running.String()
+			p.Apply(running)
+
+			if running.String() == content {
+				d.log("Configuration already up to date")
+				return nil
+			}
+			if err = d.saveConfig(c, []byte(running.String()), "patch"); err != nil {
+				return err
+			}
+			if p.Reboot {
+				return d.rebootAfterSave(c)
+			}
+			return nil
+		})
+	}), nil
+}
+
+// PatchPreview is the dry-run result of a patch for a single device.
+type PatchPreview struct {
+	MacAddress string
+	Diff       *syscfg.Diff
+	Err        error
+}
+
+// PreviewPatch computes the changes of a patch for all given devices,
+// without modifying them. Devices are processed concurrently, with the
+// bulk concurrency as limit.
+func (c *Configuration) PreviewPatch(devices []*Device, p *ConfigPatch) ([]PatchPreview, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	list := make([]PatchPreview, len(devices))
+	sem := make(chan struct{}, c.settings().Bulk.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, dev := range devices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, dev *Device) {
+			defer wg.Done()
+			diff, err := dev.PatchDiff(p)
+			list[i] = PatchPreview{MacAddress: dev.MacAddress, Diff: diff, Err: err}
+			<-sem
+		}(i, dev)
+	}
+	wg.Wait()
+	return list, nil
+}
+
+// StartPatch applies a patch to all given devices as bulk operation.
+func (c *Configuration) StartPatch(devices []*Device, p *ConfigPatch, opts BulkOptions) (*Bulk, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return c.startBulk("patch", devices, opts, func(d *Device) (*Job, error) {
+		return d.Patch(p)
+	})
+}
@@ -0,0 +1,25 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg, _ := syscfg.ParseString("snmp.community=public\nsnmp.contact=noc\nntpclient.1.server=10.0.0.1\nusers.1.password=old\n")
+	p := &ConfigPatch{
+		Set:    map[string]string{"ntpclient.1.server": "10.0.0.2", "users.1.password": "new"},
+		Delete: []string{"snmp.*"},
+	}
+	assert.NoError(p.Validate())
+	p.Apply(cfg)
+	assert.Equal("ntpclient.1.server=10.0.0.2\nusers.1.password=new\n", cfg.String())
+
+	assert.EqualError((&ConfigPatch{}).Validate(), "patch is empty")
+	assert.EqualError((&ConfigPatch{Set: map[string]string{"a=b": "c"}}).Validate(), `invalid key "a=b"`)
+	assert.EqualError((&ConfigPatch{Set: map[string]string{"a": "b\nc=d"}}).Validate(), "invalid value for key a")
+}
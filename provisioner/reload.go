@@ -0,0 +1,99 @@
+package provisioner
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// reloadCheckInterval is the polling interval of the config file watcher.
+const reloadCheckInterval = 5 * time.Second
+
+// Reload reads the config file again. If it is valid, the Settings are
+// replaced, and the devices are updated accordingly. On errors, the
+// current configuration is kept. Changes to interfaces and web require a
+// restart.
+func (c *Configuration) Reload() (errs []error) {
+	defer func() {
+		e := &Event{Type: EventConfigReload, Message: "Configuration reloaded"}
+		if len(errs) > 0 {
+			msgs := make([]string, len(errs))
+			for i, err := range errs {
+				msgs[i] = err.Error()
+			}
+			e.Type = EventAlert
+			e.Message = "Reloading configuration failed: " + strings.Join(msgs, "; ")
+		}
+		log.Printf("[config] %s", e.Message)
+		c.events.publish(e)
+	}()
+
+	next, errs := parseConfig(c.fileName)
+	if len(errs) > 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(next.InterfaceNames, c.InterfaceNames) || next.Web != c.Web {
+		log.Printf("[config] changes to interfaces and web need a restart")
+	}
+
+	// keep caches, unless their source has changed
+	cur := c.settings()
+	next.generation = cur.generation + 1
+	if next.FirmwareDirectory == cur.FirmwareDirectory {
+		next.firmwares = cur.firmwares
+	} else {
+		next.firmwares = &firmwareCache{files: make(map[string]*FirmwareFile)}
+	}
+	if next.HostKeys == cur.HostKeys {
+		next.hostKeys = cur.hostKeys
+	} else {
+		var err error
+		if next.hostKeys, err = loadHostKeys(next.HostKeys, filepath.Dir(c.fileName)); err != nil {
+			return []error{err}
+		}
+	}
+
+	checkFirmwares(&next.Settings, c.events)
+
+	c.devices.Lock()
+	c.settingsMtx.Lock()
+	c.Settings = next.Settings
+	c.settingsMtx.Unlock()
+	c.refreshCache()
+	c.devices.Unlock()
+	return nil
+}
+
+// watchConfigFile reloads the configuration, when the config file has
+// been modified (and reload_on_change is enabled).
+func (c *Configuration) watchConfigFile() {
+	for range time.Tick(reloadCheckInterval) {
+		s := c.settings()
+		if !s.ReloadOnChange {
+			continue
+		}
+		fi, err := os.Stat(c.fileName)
+		if err != nil || fi.ModTime().Equal(s.modTime) {
+			continue
+		}
+
+		// don't retry broken files
+		c.settingsMtx.Lock()
+		c.modTime = fi.ModTime()
+		c.settingsMtx.Unlock()
+
+		c.Reload()
+	}
+}
+
+// configModTime returns the modification time of the config file.
+func configModTime(fileName string) time.Time {
+	if fi, err := os.Stat(fileName); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
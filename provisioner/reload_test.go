@@ -0,0 +1,51 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.yml")
+	write := func(extra string) {
+		content := "config_directory: ./\nfirmware_directory: ./\ninterfaces: [eth0]\nweb: {port: 8080}\n" + extra
+		assert.NoError(ioutil.WriteFile(fileName, []byte(content), 0644))
+	}
+
+	write("upgrade_timeout: 5m\n")
+	c, errs := LoadConfig(fileName)
+	if !assert.Empty(errs) {
+		return
+	}
+	assert.Equal(5*time.Minute, c.UpgradeTimeout)
+	firmwares, hostKeys := c.firmwares, c.hostKeys
+	events, unsubscribe := c.Subscribe()
+	defer unsubscribe()
+
+	write("upgrade_timeout: 5m\nconfig_matching: [serial]\n")
+	assert.Len(c.Reload(), 1)
+	assert.Equal(defaultConfigMatching, c.ConfigMatching)
+	assert.Equal(EventAlert, (<-events).Type)
+
+	write("upgrade_timeout: 20m\n")
+	assert.Empty(c.Reload())
+	assert.Equal(20*time.Minute, c.settings().UpgradeTimeout)
+	assert.Equal(EventConfigReload, (<-events).Type)
+
+	// caches are kept
+	assert.True(firmwares == c.settings().firmwares)
+	assert.True(hostKeys == c.settings().hostKeys)
+	assert.EqualValues(1, c.settings().generation) // invalidates pooled SSH connections
+
+	write("upgrade_timeout: 20m\nhost_keys: {mode: strict}\n")
+	assert.Empty(c.Reload())
+	assert.Equal(HostKeysStrict, c.settings().hostKeys.mode)
+	assert.Equal(EventConfigReload, (<-events).Type)
+}
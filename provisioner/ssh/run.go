@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultTimeout limits the run time of ExecuteCommand.
+const DefaultTimeout = time.Minute
+
+// Result describes a finished remote command.
+type Result struct {
+	Command  string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int // -1, if the command did not exit regularly
+	Duration time.Duration
+}
+
+// Err returns an error for non-zero exit codes.
+func (r *Result) Err() error {
+	if r.ExitCode == 0 {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(r.Stderr)); msg != "" {
+		return fmt.Errorf("%s: exit code %d: %s", r.Command, r.ExitCode, msg)
+	}
+	return fmt.Errorf("%s: exit code %d", r.Command, r.ExitCode)
+}
+
+// Run executes a command in a new SSH session. A non-zero exit code is
+// not treated as an error (see Result.Err), but failing to start the
+// command or exceeding the context's deadline is. In the latter case,
+// the remote process is killed and the partial output is returned.
+func Run(ctx context.Context, client *ssh.Client, cmd string) (*Result, error) {
+	res := &Result{Command: cmd}
+	err := WithinSession(client, func(s *ssh.Session) error {
+		var so, se bytes.Buffer
+		s.Stdout = &so
+		s.Stderr = &se
+
+		start := time.Now()
+		if err := s.Start(cmd); err != nil {
+			return err
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- s.Wait() }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			s.Signal(ssh.SIGKILL)
+			s.Close()
+			<-done
+			err = ctx.Err()
+		}
+		res.Duration = time.Since(start)
+		res.Stdout, res.Stderr = so.Bytes(), se.Bytes()
+
+		switch e := err.(type) {
+		case nil:
+		case *ssh.ExitError:
+			res.ExitCode = e.ExitStatus()
+			if e.Signal() != "" {
+				res.ExitCode = -1
+			}
+			err = nil
+		default:
+			res.ExitCode = -1
+		}
+		return err
+	})
+	return res, err
+}
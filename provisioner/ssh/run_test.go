@@ -0,0 +1,170 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestResultErr(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&Result{Command: "true"}).Err())
+	assert.EqualError((&Result{Command: "false", ExitCode: 1}).Err(), "false: exit code 1")
+	assert.EqualError((&Result{Command: "cat x", ExitCode: 1, Stderr: []byte("cat: can't open 'x'\n")}).Err(),
+		"cat x: exit code 1: cat: can't open 'x'")
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	killed := make(chan string, 1)
+	client := testClient(t, func(cmd string, ch ssh.Channel, signals <-chan string) {
+		switch cmd {
+		case "echo":
+			io.WriteString(ch, "out\n")
+			io.WriteString(ch.Stderr(), "err\n")
+			exitStatus(ch, 0)
+		case "false":
+			io.WriteString(ch.Stderr(), "failed\n")
+			exitStatus(ch, 3)
+		case "crash":
+			ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: "SEGV"}))
+		case "sleep":
+			io.WriteString(ch, "started\n")
+			select {
+			case sig := <-signals:
+				killed <- sig
+			case <-time.After(5 * time.Second):
+				exitStatus(ch, 0)
+			}
+		}
+	})
+
+	res, err := Run(context.Background(), client, "echo")
+	if assert.NoError(err) {
+		assert.Equal("out\n", string(res.Stdout))
+		assert.Equal("err\n", string(res.Stderr))
+		assert.Equal(0, res.ExitCode)
+		assert.NoError(res.Err())
+	}
+
+	res, err = Run(context.Background(), client, "false")
+	if assert.NoError(err) {
+		assert.Empty(res.Stdout)
+		assert.Equal(3, res.ExitCode)
+		assert.EqualError(res.Err(), "false: exit code 3: failed")
+	}
+
+	res, err = Run(context.Background(), client, "crash")
+	if assert.NoError(err) {
+		assert.Equal(-1, res.ExitCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	res, err = Run(ctx, client, "sleep")
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(-1, res.ExitCode)
+	assert.Equal("started\n", string(res.Stdout))
+	assert.Less(res.Duration, 5*time.Second)
+	select {
+	case sig := <-killed:
+		assert.Equal(string(ssh.SIGKILL), sig)
+	case <-time.After(time.Second):
+		assert.Fail("remote process was not killed")
+	}
+}
+
+func exitStatus(ch ssh.Channel, status uint32) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+// testClient connects to an in-process SSH server, which runs commands
+// with the given handler. Signals sent by the client are passed to the
+// handler, the session is closed once it returns.
+func testClient(t *testing.T, handler func(cmd string, ch ssh.Channel, signals <-chan string)) *ssh.Client {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(nc, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for nch := range chans {
+			ch, reqs, err := nch.Accept()
+			if err != nil {
+				continue
+			}
+			go serveSession(ch, reqs, handler)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "ubnt",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveSession(ch ssh.Channel, reqs <-chan *ssh.Request, handler func(string, ssh.Channel, <-chan string)) {
+	signals := make(chan string, 1)
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var exec struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				handler(exec.Command, ch, signals)
+				ch.Close()
+			}()
+		case "signal":
+			var sig struct{ Signal string }
+			if ssh.Unmarshal(req.Payload, &sig) == nil {
+				select {
+				case signals <- sig.Signal:
+				default:
+				}
+			}
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
@@ -1,18 +1,12 @@
 package ssh
 
 import (
-	"bytes"
-	"crypto/x509"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
-	"path/filepath"
-
-	"encoding/pem"
-
-	"strings"
 
 	"github.com/digineo/goldflags"
 	"golang.org/x/crypto/ssh"
@@ -29,8 +23,8 @@ func Agent() ssh.AuthMethod {
 		return nil
 	}
 
-	sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
-	if err == nil {
+	sshAgent, err := net.Dial("unix", sock)
+	if err != nil {
 		log.Printf("[ssh.Agent] Couldn't connect to SSH agent: %v", err)
 		return nil
 	}
@@ -45,65 +39,68 @@ func Agent() ssh.AuthMethod {
 	return ssh.PublicKeysCallback(agent.Signers)
 }
 
-// ReadPrivateKey tries to read an SSH private key file.
-func ReadPrivateKey(keyPath, password string) (auth ssh.AuthMethod, ok bool) {
+// ReadPrivateKey reads an SSH private key file. All formats understood by
+// OpenSSH are supported (PKCS#1, PKCS#8, SEC1 and the OpenSSH format, with
+// RSA, ECDSA, DSA and Ed25519 keys). The password is used to decrypt
+// protected keys.
+//
+// If a certificate (<keyPath>-cert.pub) exists next to the key, it is
+// presented instead of the plain public key.
+func ReadPrivateKey(keyPath, password string) (ssh.AuthMethod, error) {
 	keyFile, err := goldflags.ExpandPath(keyPath)
 	if err != nil {
-		log.Printf("[ssh.ReadPrivateKey] Could not expand %s: %v", keyPath, err)
-		return
-	}
-
-	if !goldflags.PathExist(keyFile) {
-		log.Printf("[ssh.ReadPrivateKey] Keyfile %s not found", keyFile)
-		return
+		return nil, fmt.Errorf("could not expand %s: %v", keyPath, err)
 	}
 
 	keyPEM, err := ioutil.ReadFile(keyFile)
 	if err != nil {
-		log.Printf("[ssh.ReadPrivateKey] Could not read %s: %v", keyFile, err)
-		return
+		return nil, err
 	}
 
-	block, _ := pem.Decode(keyPEM)
-	if block == nil {
-		log.Printf("[ssh.ReadPrivateKey] No key found in %s", keyFile)
-		return
+	signer, err := ParsePrivateKey(keyPEM, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", keyFile, err)
 	}
 
-	keyFrom := block.Bytes
-	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
-		keyFrom, err = x509.DecryptPEMBlock(block, []byte(password))
-		if err != nil {
-			log.Printf("[ssh.ReadPrivateKey] Error decrypting %s: %v", keyFile, err)
-			return
-		}
+	certFile := keyFile + "-cert.pub"
+	if !goldflags.PathExist(certFile) {
+		return ssh.PublicKeys(signer), nil
 	}
-
-	key, err := getKey(block.Type, keyFrom)
+	certData, err := ioutil.ReadFile(certFile)
 	if err != nil {
-		log.Printf("[ssh.ReadPrivateKey] %s: %v", keyFile, err)
-		return
+		return nil, err
 	}
+	if signer, err = CertSigner(signer, certData); err != nil {
+		return nil, fmt.Errorf("%s: %v", certFile, err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
 
-	sign, err := ssh.NewSignerFromKey(key)
-	if err != nil {
-		log.Printf("[ssh.ReadPrivateKey] %s: %v", keyFile, err)
-		return
+// ParsePrivateKey parses a PEM encoded private key. Encrypted keys require
+// a password, which is ignored for unencrypted keys.
+func ParsePrivateKey(keyPEM []byte, password string) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(keyPEM)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if password == "" {
+			return nil, fmt.Errorf("key is encrypted, but no password given")
+		}
+		return ssh.ParsePrivateKeyWithPassphrase(keyPEM, []byte(password))
 	}
-	return ssh.PublicKeys(sign), true
+	return signer, err
 }
 
-func getKey(typ string, b []byte) (interface{}, error) {
-	switch typ {
-	case "RSA PRIVATE KEY":
-		return x509.ParsePKCS1PrivateKey(b)
-	case "EC PRIVATE KEY":
-		return x509.ParseECPrivateKey(b)
-	case "DSA PRIVATE KEY":
-		return ssh.ParseDSAPrivateKey(b)
-	default:
-		return nil, fmt.Errorf("unsupported key type %q", typ)
+// CertSigner combines a signer with a certificate (in authorized_keys
+// format, as found in *-cert.pub files) for the same key.
+func CertSigner(signer ssh.Signer, certData []byte) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		return nil, err
 	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate")
+	}
+	return ssh.NewCertSigner(cert, signer)
 }
 
 // WithinSession executes a callback function within a new SSH session of
@@ -117,77 +114,32 @@ func WithinSession(client *ssh.Client, callback sshSessionCallback) error {
 	return callback(session)
 }
 
-// UploadFile uploads a local file to the remote. Please avoid funky
-// remote file names, since there's no protection against command injection
-func UploadFile(client *ssh.Client, localName string, remoteName string) error {
-	return WithinSession(client, func(s *ssh.Session) error {
-		writer, err := s.StdinPipe()
-		if err != nil {
-			return err
-		}
-		defer writer.Close()
-
-		buf, err := ioutil.ReadFile(localName)
-		if err != nil {
-			return err
-		}
-		log.Printf("[ssh.UploadFile local-file] %s, %d bytes", localName, len(buf))
-
-		rdir := filepath.Dir(remoteName)
-		log.Printf("[ssh.UploadFile remote-dir] %s", rdir)
-
-		rfile := filepath.Base(remoteName)
-		log.Printf("[ssh.UploadFile remote-file] %s", rfile)
-
-		var so, se bytes.Buffer
-		s.Stdout = &so
-		s.Stderr = &se
-
-		cmd := fmt.Sprintf("/usr/bin/scp -t %s", rdir) // danger!
-		log.Printf("[ssh.UploadFile command] %s", cmd)
-
-		if err := s.Start(cmd); err != nil {
-			return err
-		}
-
-		content := string(buf)
-		log.Printf("[ssh.UploadFile uploading] %d bytes", len(content))
-
-		// https://blogs.oracle.com/janp/entry/how_the_scp_protocol_works
-		fmt.Fprintln(writer, "C0644", len(content), rfile)
-		fmt.Fprint(writer, content)
-		fmt.Fprint(writer, "\x00")
-		writer.Close()
-
-		if err := s.Wait(); err != nil {
-			log.Println("[ssh.UploadFile] waiting failed")
-			log.Printf("[ssh.UploadFile stderr] %s", se.String())
-			log.Printf("[ssh.UploadFile stdout] %s", so.String())
-			return err
-		}
+// ExecuteCommand executes a command in a new SSH session and returns its
+// standard output. Non-zero exit codes are reported as errors, which
+// include the standard error output.
+func ExecuteCommand(client *ssh.Client, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
 
-		return nil
-	})
+	res, err := Run(ctx, client, cmd)
+	if err == nil {
+		err = res.Err()
+	}
+	if err != nil {
+		log.Printf("[ssh.ExecuteCommand] %s failed: %v", cmd, err)
+		return "", err
+	}
+	return string(res.Stdout), nil
 }
 
-// ExecuteCommand executes a command in a new SSH session.
-func ExecuteCommand(client *ssh.Client, cmd string) (string, error) {
+// Output executes a command in a new SSH session and returns its
+// standard output.
+func Output(client *ssh.Client, cmd string) (string, error) {
 	var output string
 	sessionErr := WithinSession(client, func(s *ssh.Session) error {
-		var so, se bytes.Buffer
-		s.Stdout = &so
-		s.Stderr = &se
-
-		if err := s.Run(cmd); err != nil {
-			log.Printf("[executeCommand] %s failed", cmd)
-			log.Printf("[executeCommand stderr] %s", se.String())
-			log.Printf("[executeCommand stdout] %s", so.String())
-			return err
-		}
-
-		output = se.String()
-		return nil
+		out, err := s.Output(cmd)
+		output = string(out)
+		return err
 	})
-
 	return output, sessionErr
 }
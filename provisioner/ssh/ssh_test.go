@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(err)
+
+	openssh, err := ssh.MarshalPrivateKey(edKey, "")
+	assert.NoError(err)
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(edKey, "", []byte("secret"))
+	assert.NoError(err)
+
+	keys := map[string][]byte{
+		"openssh": pem.EncodeToMemory(openssh),
+		"pkcs1":   pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"pkcs8":   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+	for name, key := range keys {
+		_, err = ParsePrivateKey(key, "")
+		assert.NoError(err, name)
+
+		// the password is ignored for unencrypted keys
+		_, err = ParsePrivateKey(key, "secret")
+		assert.NoError(err, name)
+	}
+
+	encPEM := pem.EncodeToMemory(encrypted)
+	_, err = ParsePrivateKey(encPEM, "")
+	assert.EqualError(err, "key is encrypted, but no password given")
+	_, err = ParsePrivateKey(encPEM, "wrong")
+	assert.Error(err)
+	signer, err := ParsePrivateKey(encPEM, "secret")
+	if assert.NoError(err) {
+		assert.Equal(ssh.KeyAlgoED25519, signer.PublicKey().Type())
+	}
+}
+
+func TestCertSigner(t *testing.T) {
+	assert := assert.New(t)
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, _ := ssh.NewSignerFromKey(caKey)
+	signer, _ := ssh.NewSignerFromKey(userKey)
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"ubnt"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(cert.SignCert(rand.Reader, ca))
+
+	certSigner, err := CertSigner(signer, ssh.MarshalAuthorizedKey(cert))
+	if assert.NoError(err) {
+		assert.Equal(ssh.CertAlgoED25519v01, certSigner.PublicKey().Type())
+	}
+
+	_, err = CertSigner(signer, ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	assert.EqualError(err, "not a certificate")
+}
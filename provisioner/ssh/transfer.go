@@ -0,0 +1,277 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// progressInterval limits how often a ProgressFunc is called.
+const progressInterval = time.Second
+
+// Progress describes the state of a file transfer.
+type Progress struct {
+	Bytes int64   // transferred so far
+	Total int64   // -1, if unknown
+	Rate  float64 // bytes per second
+}
+
+// ProgressFunc is called periodically during a transfer, and once when
+// it has finished.
+type ProgressFunc func(Progress)
+
+// progressCounter counts the bytes passing through a reader or writer
+// and reports them to a ProgressFunc.
+type progressCounter struct {
+	fn      ProgressFunc
+	total   int64
+	bytes   int64
+	started time.Time
+	lastRun time.Time
+}
+
+func newProgressCounter(total int64, fn ProgressFunc) *progressCounter {
+	now := time.Now()
+	return &progressCounter{fn: fn, total: total, started: now, lastRun: now}
+}
+
+func (p *progressCounter) add(n int) {
+	p.bytes += int64(n)
+	if time.Since(p.lastRun) >= progressInterval {
+		p.report()
+	}
+}
+
+func (p *progressCounter) report() {
+	p.lastRun = time.Now()
+	if p.fn == nil {
+		return
+	}
+	prog := Progress{Bytes: p.bytes, Total: p.total}
+	if d := p.lastRun.Sub(p.started).Seconds(); d > 0 {
+		prog.Rate = float64(p.bytes) / d
+	}
+	p.fn(prog)
+}
+
+type progressReader struct {
+	io.Reader
+	*progressCounter
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	*progressCounter
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.add(n)
+	return n, err
+}
+
+// shellQuote quotes s for the POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// UploadFile uploads a local file to the remote (see UploadStream).
+func UploadFile(client *ssh.Client, localName, remoteName string, progress ProgressFunc) error {
+	f, err := os.Open(localName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	log.Printf("[ssh.UploadFile] %s -> %s, %d bytes", localName, remoteName, fi.Size())
+	return UploadStream(client, f, fi.Size(), remoteName, progress)
+}
+
+// Upload writes content to a remote file (see UploadStream).
+func Upload(client *ssh.Client, buf []byte, remoteName string) error {
+	return UploadStream(client, bytes.NewReader(buf), int64(len(buf)), remoteName, nil)
+}
+
+// UploadStream writes size bytes read from r to a remote file. SFTP is
+// used if the remote supports it, scp otherwise. The progress function
+// may be nil.
+func UploadStream(client *ssh.Client, r io.Reader, size int64, remoteName string, progress ProgressFunc) error {
+	counter := newProgressCounter(size, progress)
+	r = &progressReader{Reader: r, progressCounter: counter}
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		log.Printf("[ssh.UploadStream] SFTP not available (%v), using scp", err)
+		err = scpUpload(client, r, size, remoteName)
+	} else {
+		defer sc.Close()
+		err = sftpUpload(sc, r, remoteName)
+	}
+	if err == nil {
+		counter.report()
+	}
+	return err
+}
+
+func sftpUpload(sc *sftp.Client, r io.Reader, remoteName string) error {
+	f, err := sc.Create(remoteName)
+	if err != nil {
+		return err
+	}
+	if _, err = f.ReadFrom(r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// scpUpload implements the sink side of the scp protocol, see
+// https://blogs.oracle.com/janp/entry/how_the_scp_protocol_works
+func scpUpload(client *ssh.Client, r io.Reader, size int64, remoteName string) error {
+	if size < 0 {
+		return fmt.Errorf("scp requires the file size")
+	}
+	rdir, rfile := path.Split(remoteName)
+	if rdir == "" {
+		rdir = "."
+	}
+	if rfile == "" || strings.ContainsAny(rfile, "\r\n") {
+		return fmt.Errorf("invalid remote file name %q", remoteName)
+	}
+
+	return WithinSession(client, func(s *ssh.Session) error {
+		writer, err := s.StdinPipe()
+		if err != nil {
+			return err
+		}
+		defer writer.Close()
+
+		stdout, err := s.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		acks := bufio.NewReader(stdout)
+
+		var se bytes.Buffer
+		s.Stderr = &se
+
+		if err = s.Start("/usr/bin/scp -t " + shellQuote(rdir)); err != nil {
+			return err
+		}
+
+		err = scpAck(acks)
+		if err == nil {
+			fmt.Fprintf(writer, "C0644 %d %s\n", size, rfile)
+			err = scpAck(acks)
+		}
+		if err == nil {
+			if _, err = io.CopyN(writer, r, size); err == nil {
+				fmt.Fprint(writer, "\x00")
+				err = scpAck(acks)
+			}
+		}
+		writer.Close()
+
+		if waitErr := s.Wait(); err == nil && waitErr != nil {
+			err = waitErr
+		}
+		if err != nil && se.Len() > 0 {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(se.String()))
+		}
+		return err
+	})
+}
+
+// scpAck reads a response of the remote scp process.
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// DownloadFile copies a remote file to a local file (see Download).
+func DownloadFile(client *ssh.Client, remoteName, localName string, progress ProgressFunc) error {
+	f, err := os.Create(localName)
+	if err != nil {
+		return err
+	}
+	if _, err = Download(client, remoteName, f, progress); err != nil {
+		f.Close()
+		os.Remove(localName)
+		return err
+	}
+	return f.Close()
+}
+
+// Download writes the content of a remote file to w and returns the
+// number of bytes written. SFTP is used if the remote supports it, cat
+// otherwise. The progress function may be nil.
+func Download(client *ssh.Client, remoteName string, w io.Writer, progress ProgressFunc) (int64, error) {
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		log.Printf("[ssh.Download] SFTP not available (%v), using cat", err)
+		counter := newProgressCounter(-1, progress)
+		err = catDownload(client, remoteName, &progressWriter{Writer: w, progressCounter: counter})
+		if err == nil {
+			counter.report()
+		}
+		return counter.bytes, err
+	}
+	defer sc.Close()
+
+	f, err := sc.Open(remoteName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+	counter := newProgressCounter(size, progress)
+	if _, err = io.Copy(&progressWriter{Writer: w, progressCounter: counter}, f); err == nil {
+		counter.report()
+	}
+	return counter.bytes, err
+}
+
+func catDownload(client *ssh.Client, remoteName string, w io.Writer) error {
+	return WithinSession(client, func(s *ssh.Session) error {
+		var se bytes.Buffer
+		s.Stdout = w
+		s.Stderr = &se
+		if err := s.Run("cat " + shellQuote(remoteName)); err != nil {
+			if se.Len() > 0 {
+				return fmt.Errorf("%v: %s", err, strings.TrimSpace(se.String()))
+			}
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(`'/tmp/fwupdate.bin'`, shellQuote("/tmp/fwupdate.bin"))
+	assert.Equal(`'/tmp/a'"'"'; reboot'`, shellQuote("/tmp/a'; reboot"))
+}
+
+func TestProgressReader(t *testing.T) {
+	assert := assert.New(t)
+
+	var reports []Progress
+	counter := newProgressCounter(5, func(p Progress) { reports = append(reports, p) })
+	r := &progressReader{Reader: strings.NewReader("hello"), progressCounter: counter}
+
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+	assert.Empty(reports) // below progressInterval
+
+	counter.report()
+	if assert.Len(reports, 1) {
+		assert.EqualValues(5, reports[0].Bytes)
+		assert.EqualValues(5, reports[0].Total)
+	}
+}
+
+func TestScpAck(t *testing.T) {
+	assert := assert.New(t)
+
+	r := bufio.NewReader(bytes.NewBufferString("\x00\x01scp: /tmp/x: No space left on device\n"))
+	assert.NoError(scpAck(r))
+	assert.EqualError(scpAck(r), "scp: scp: /tmp/x: No space left on device")
+	assert.Error(scpAck(r)) // EOF
+}
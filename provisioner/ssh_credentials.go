@@ -0,0 +1,99 @@
+package provisioner
+
+import (
+	"fmt"
+
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultSSHUser = "ubnt"
+	defaultSSHPort = 22
+)
+
+type sshAuthMethod struct {
+	Type     string `yaml:"type"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Path     string `yaml:"path"`
+}
+
+// sshCredentialSet assigns auth methods to the devices matched by the
+// filter and group (as assigned in templates.variables).
+type sshCredentialSet struct {
+	DeviceFilter `yaml:",inline"`
+	Group        string          `yaml:"group"`
+	Port         int             `yaml:"port"` // overrides the discovered port
+	Methods      []sshAuthMethod `yaml:"methods"`
+
+	credentials []sshCredential
+}
+
+// sshCredential is a usable auth method, together with the user name.
+type sshCredential struct {
+	id     string // e.g. "ssh.0" or "ssh_credentials.1.0"
+	typ    string
+	user   string
+	port   int // 0 if not overridden
+	method ssh.AuthMethod
+}
+
+// buildCredentials converts the configured auth methods. Empty passwords
+// and an unavailable ssh-agent are skipped, unreadable keys are reported
+// as errors. The prefix is used to identify the credentials.
+func buildCredentials(prefix string, methods []sshAuthMethod, port int) (list []sshCredential, errs []error) {
+	for i, m := range methods {
+		cred := sshCredential{
+			id:   fmt.Sprintf("%s.%d", prefix, i),
+			typ:  m.Type,
+			user: m.User,
+			port: port,
+		}
+		if cred.user == "" {
+			cred.user = defaultSSHUser
+		}
+
+		switch m.Type {
+		case "", "password": // Type=="" is an alias for password
+			if m.Password != "" {
+				cred.typ = "password"
+				cred.method = ssh.Password(m.Password)
+			}
+		case "ssh-agent":
+			if a := pssh.Agent(); a != nil {
+				cred.method = a
+			}
+		case "keyfile":
+			key, err := pssh.ReadPrivateKey(m.Path, m.Password)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: keyfile: %v", cred.id, err))
+			} else {
+				cred.method = key
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown auth method %q", m.Type))
+		}
+
+		if cred.method != nil {
+			list = append(list, cred)
+		}
+	}
+	return
+}
+
+// credentialsFor returns the auth methods of the first matching
+// credential set, or the global ones.
+func (c *Configuration) credentialsFor(dev *Device) []sshCredential {
+	for i := range c.SSHCredentials {
+		set := &c.SSHCredentials[i]
+		if !set.Match(dev) {
+			continue
+		}
+		if set.Group != "" && (c.templates == nil || c.templates.vars.lookup(dev.MacAddress, dev.Model)["group"] != set.Group) {
+			continue
+		}
+		return set.credentials
+	}
+	return c.sshCredentials
+}
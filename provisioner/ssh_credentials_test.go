@@ -0,0 +1,50 @@
+package provisioner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsFor(t *testing.T) {
+	assert := assert.New(t)
+
+	global, errs := buildCredentials("ssh", []sshAuthMethod{{Password: "ubnt"}, {Type: "password"}}, 0)
+	assert.Empty(errs)
+	assert.Len(global, 1) // empty password is skipped
+	assert.Equal("ubnt", global[0].user)
+
+	_, errs = buildCredentials("ssh", []sshAuthMethod{{Type: "telnet"}}, 0)
+	assert.Len(errs, 1)
+
+	c := &Configuration{Settings: Settings{
+		sshCredentials: global,
+		templates: &configTemplates{vars: &templateVars{
+			Devices: map[string]map[string]string{"001122aabbcc": {"group": "tower"}},
+		}},
+		SSHCredentials: []sshCredentialSet{
+			{Group: "tower", Methods: []sshAuthMethod{{User: "admin", Password: "secret"}}},
+			{DeviceFilter: DeviceFilter{Model: "LiteBeam*"}, Port: 2222, Methods: []sshAuthMethod{{Password: "lb"}}},
+		},
+	}}
+	for i := range c.SSHCredentials {
+		c.SSHCredentials[i].credentials, _ = buildCredentials(fmt.Sprintf("ssh_credentials.%d", i), c.SSHCredentials[i].Methods, c.SSHCredentials[i].Port)
+	}
+
+	tower := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", Model: "LiteBeam 5AC", SSHPort: 2200})
+	creds := c.credentialsFor(tower)
+	assert.Equal("admin", creds[0].user)
+	assert.Equal(2200, tower.sshPortFor(tower.cfg, creds[0]))
+
+	lb := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cd", Model: "LiteBeam 5AC", SSHPort: 2200})
+	creds = c.credentialsFor(lb)
+	assert.Equal("ubnt", creds[0].user)
+	assert.Equal(2222, lb.sshPortFor(lb.cfg, creds[0]))
+
+	other := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:ce", Model: "NanoBeam"})
+	creds = c.credentialsFor(other)
+	assert.Equal(global, creds)
+	assert.Equal(defaultSSHPort, other.sshPortFor(other.cfg, creds[0]))
+}
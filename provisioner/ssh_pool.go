@@ -0,0 +1,208 @@
+package provisioner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Defaults for SSHPoolOptions.
+const (
+	defaultSSHIdleTimeout = time.Minute
+	defaultSSHKeepAlive   = 15 * time.Second
+)
+
+// sshProbeTimeout limits the time to wait for a keepalive reply.
+const sshProbeTimeout = 2 * time.Second
+
+// SSHPoolOptions controls the reuse of SSH connections. Connections are
+// kept open for IdleTimeout after their last use, and are probed every
+// KeepAlive interval. Disabled opens a new connection for every operation.
+type SSHPoolOptions struct {
+	Disabled    bool          `yaml:"disabled"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	KeepAlive   time.Duration `yaml:"keepalive"`
+}
+
+// sshConn is a pooled SSH connection of a device. It may be shared by
+// concurrent operations.
+type sshConn struct {
+	client   *ssh.Client
+	key      string    // see Device.sshPoolKey
+	users    int       // number of operations using the connection
+	lastUsed time.Time // when the last user released it
+	stale    bool      // close once unused, don't hand out again
+	closed   chan struct{}
+}
+
+// sshPool holds the connection of a device.
+type sshPool struct {
+	conn *sshConn
+	mtx  sync.Mutex
+}
+
+// alive sends a keepalive request and waits for the reply.
+func (c *sshConn) alive() bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(sshProbeTimeout):
+		return false
+	}
+}
+
+func (c *sshConn) close() {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+		c.client.Close()
+	}
+}
+
+// sshPoolKey identifies the target of a pooled connection. Connections
+// with another key are outdated, since the IP address, the port or the
+// credentials (which may change with every reload) have changed.
+func (d *Device) sshPoolKey(cfg *deviceSettings) string {
+	return fmt.Sprintf("%s:%d/%d", cfg.ipAddress, d.Snapshot().SSHPort, cfg.credGeneration)
+}
+
+// acquireSSH returns a connected client and a function, which must be
+// called once the client is no longer needed. A pooled connection is
+// reused, if it is still alive. Otherwise, a new connection is dialed.
+// The pool lock is not held while probing or dialing.
+func (d *Device) acquireSSH() (*ssh.Client, func(), error) {
+	cfg := d.settings()
+	opts := cfg.sshPool
+	if opts.Disabled {
+		client, err := d.dialSSH(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { client.Close() }, nil
+	}
+
+	key := d.sshPoolKey(cfg)
+
+	d.pool.mtx.Lock()
+	conn := d.pool.conn
+	if conn != nil && (conn.stale || conn.key != key) {
+		d.detachSSH(conn)
+		conn = nil
+	}
+	if conn != nil {
+		conn.users++ // keep it open while probing
+	}
+	d.pool.mtx.Unlock()
+
+	if conn != nil {
+		if conn.alive() {
+			return conn.client, d.releaser(conn), nil
+		}
+		d.log("Discarding SSH connection")
+		d.pool.mtx.Lock()
+		conn.users--
+		d.detachSSH(conn)
+		d.pool.mtx.Unlock()
+	}
+
+	client, err := d.dialSSH(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn = &sshConn{client: client, key: key, users: 1, closed: make(chan struct{})}
+
+	d.pool.mtx.Lock()
+	if d.pool.conn == nil {
+		d.pool.conn = conn
+		go d.keepSSHAlive(conn, opts)
+	} else {
+		conn.stale = true // dialed concurrently, use it only once
+	}
+	d.pool.mtx.Unlock()
+
+	return conn.client, d.releaser(conn), nil
+}
+
+// releaser returns a function, which releases conn once.
+func (d *Device) releaser(conn *sshConn) func() {
+	var once sync.Once
+	return func() { once.Do(func() { d.releaseSSH(conn) }) }
+}
+
+func (d *Device) releaseSSH(conn *sshConn) {
+	d.pool.mtx.Lock()
+	defer d.pool.mtx.Unlock()
+
+	conn.users--
+	conn.lastUsed = time.Now()
+	if conn.stale && conn.users == 0 {
+		conn.close()
+	}
+}
+
+// detachSSH removes conn from the pool and closes it, once it is unused.
+// The caller must hold the pool lock.
+func (d *Device) detachSSH(conn *sshConn) {
+	conn.stale = true
+	if d.pool.conn == conn {
+		d.pool.conn = nil
+	}
+	if conn.users == 0 {
+		conn.close()
+	}
+}
+
+// dropSSH discards the pooled connection (i.e. before a reboot). Running
+// operations may continue to use it.
+func (d *Device) dropSSH() {
+	d.pool.mtx.Lock()
+	defer d.pool.mtx.Unlock()
+	if conn := d.pool.conn; conn != nil {
+		d.detachSSH(conn)
+	}
+}
+
+// keepSSHAlive probes conn periodically, and closes it when it is dead
+// or idle for too long.
+func (d *Device) keepSSHAlive(conn *sshConn, opts SSHPoolOptions) {
+	idleTimeout, interval := opts.IdleTimeout, opts.KeepAlive
+	if idleTimeout <= 0 {
+		idleTimeout = defaultSSHIdleTimeout
+	}
+	if interval <= 0 {
+		interval = defaultSSHKeepAlive
+	}
+	if interval > idleTimeout {
+		interval = idleTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.closed:
+			return
+		case <-ticker.C:
+		}
+
+		d.pool.mtx.Lock()
+		idle := conn.users == 0 && time.Since(conn.lastUsed) >= idleTimeout
+		d.pool.mtx.Unlock()
+
+		if idle || !conn.alive() {
+			d.pool.mtx.Lock()
+			d.detachSSH(conn)
+			d.pool.mtx.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package provisioner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer accepts any password and counts the connections.
+// Sessions are rejected.
+func testSSHServer(t *testing.T) (port int, conns *int32, stop func()) {
+	return startTestSSHServer(t, func(ch ssh.NewChannel) {
+		ch.Reject(ssh.Prohibited, "no sessions")
+	})
+}
+
+// testExecServer is like testSSHServer, but runs exec requests with the
+// given handler, which returns the exit status. Subsystems (i.e. SFTP)
+// are not available.
+func testExecServer(t *testing.T, handler func(cmd string, stdout io.Writer) uint32) (port int, stop func()) {
+	port, _, stop = startTestSSHServer(t, func(nc ssh.NewChannel) {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			return
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+
+		for req := range reqs {
+			var exec struct{ Command string }
+			if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			status := handler(exec.Command, ch)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		}
+	})
+	return
+}
+
+func startTestSSHServer(t *testing.T, handle func(ssh.NewChannel)) (port int, conns *int32, stop func()) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns = new(int32)
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(nc, config)
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(conns, 1)
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					go handle(ch)
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, conns, func() { l.Close() }
+}
+
+func TestSSHPool(t *testing.T) {
+	assert := assert.New(t)
+
+	port, conns, stop := testSSHServer(t)
+	defer stop()
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "ubnt"}}, port)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.ipAddress = "127.0.0.1"
+	dev.cfg.credentials = creds
+	dev.cfg.sshPool = SSHPoolOptions{IdleTimeout: 100 * time.Millisecond, KeepAlive: 20 * time.Millisecond}
+
+	c1, release1, err := dev.acquireSSH()
+	if !assert.NoError(err) {
+		return
+	}
+	c2, release2, err := dev.acquireSSH()
+	assert.NoError(err)
+	assert.True(c1 == c2, "connection should be reused")
+	release1()
+	release2()
+	assert.EqualValues(1, atomic.LoadInt32(conns))
+
+	// dropped connections are replaced
+	dev.dropSSH()
+	c3, release3, err := dev.acquireSSH()
+	assert.NoError(err)
+	assert.False(c1 == c3)
+	release3()
+	assert.EqualValues(2, atomic.LoadInt32(conns))
+
+	// idle connections are closed
+	time.Sleep(300 * time.Millisecond)
+	dev.pool.mtx.Lock()
+	assert.Nil(dev.pool.conn)
+	dev.pool.mtx.Unlock()
+}
+
+func TestSSHPoolKey(t *testing.T) {
+	assert := assert.New(t)
+
+	port, conns, stop := testSSHServer(t)
+	defer stop()
+
+	creds, _ := buildCredentials("ssh", []sshAuthMethod{{Password: "ubnt"}}, port)
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc"})
+	dev.cfg.ipAddress = "127.0.0.1"
+	dev.cfg.credentials = creds
+
+	c1, release1, err := dev.acquireSSH()
+	if !assert.NoError(err) {
+		return
+	}
+
+	// credentials changed (e.g. by a reload)
+	next := *dev.settings()
+	next.credGeneration++
+	dev.setSettings(&next)
+	c2, release2, err := dev.acquireSSH()
+	assert.NoError(err)
+	assert.False(c1 == c2, "outdated connection should not be reused")
+	assert.EqualValues(2, atomic.LoadInt32(conns))
+
+	// the outdated connection is closed, once released
+	release1()
+	assert.Error(c1.Wait())
+	release2()
+
+	c3, release3, err := dev.acquireSSH()
+	assert.NoError(err)
+	assert.True(c2 == c3)
+	release3()
+	dev.dropSSH()
+}
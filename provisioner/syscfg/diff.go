@@ -0,0 +1,48 @@
+package syscfg
+
+import "sort"
+
+// Change describes a single differing key of two configurations. For
+// added keys, Old is empty, for removed keys New is empty.
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+// Diff lists the changes needed to turn one configuration into another.
+// All lists are sorted by key.
+type Diff struct {
+	Added   []Change
+	Removed []Change
+	Changed []Change
+}
+
+// Empty is true, if both configurations are equal.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare computes the changes from a to b. Comments and ordering are
+// ignored.
+func Compare(a, b *Config) *Diff {
+	diff := &Diff{}
+
+	for key, l := range b.index {
+		if o, ok := a.index[key]; !ok {
+			diff.Added = append(diff.Added, Change{Key: key, New: l.value})
+		} else if o.value != l.value {
+			diff.Changed = append(diff.Changed, Change{Key: key, Old: o.value, New: l.value})
+		}
+	}
+	for key, l := range a.index {
+		if _, ok := b.index[key]; !ok {
+			diff.Removed = append(diff.Removed, Change{Key: key, Old: l.value})
+		}
+	}
+
+	for _, list := range [][]Change{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	}
+	return diff
+}
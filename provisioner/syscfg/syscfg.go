@@ -0,0 +1,356 @@
+// Package syscfg reads and writes the system configuration of AirOS
+// devices (/tmp/system.cfg).
+//
+// The file consists of "key=value" lines, where keys are dot-separated
+// paths. Numeric path elements denote indexed sections, e.g.:
+//
+//	radio.1.status=enabled
+//	radio.1.channel=36
+//	vlan.2.id=42
+//
+// A Config keeps the order of all lines (including comments and blank
+// lines), so that reading and writing an unmodified file is lossless.
+package syscfg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// line is either a key/value pair or a verbatim line (comment, blank
+// line or anything else we don't understand). The original text is kept
+// in raw, and written back unless the value was modified.
+type line struct {
+	key   string
+	value string
+	raw   string
+	dirty bool // value was modified (or line was added)
+}
+
+func (l *line) isValue() bool {
+	return l.key != ""
+}
+
+func (l *line) String() string {
+	if l.isValue() && l.dirty {
+		if strings.HasSuffix(l.raw, "\r") {
+			return l.key + "=" + l.value + "\r" // keep CRLF line ending
+		}
+		return l.key + "=" + l.value
+	}
+	return l.raw
+}
+
+// Config is an ordered list of configuration lines.
+type Config struct {
+	lines []*line
+	index map[string]*line
+	noEOL bool // last line is not terminated by a newline
+}
+
+// New returns an empty configuration.
+func New() *Config {
+	return &Config{index: make(map[string]*line)}
+}
+
+// Open reads a configuration file.
+func Open(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads a configuration from r. If a key occurs multiple times,
+// the last value wins (as with AirOS), but all lines are kept.
+func Parse(r io.Reader) (*Config, error) {
+	c := New()
+	reader := bufio.NewReader(r)
+	for {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if text == "" {
+			break
+		}
+		if strings.HasSuffix(text, "\n") {
+			text = text[:len(text)-1]
+		} else {
+			c.noEOL = true
+		}
+		c.lines = append(c.lines, parseLine(text))
+
+		if err == io.EOF {
+			break
+		}
+	}
+	for _, l := range c.lines {
+		if l.isValue() {
+			c.index[l.key] = l
+		}
+	}
+	return c, nil
+}
+
+func parseLine(text string) *line {
+	l := &line{raw: text}
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" || trimmed[0] == '#' {
+		return l
+	}
+	if i := strings.IndexByte(text, '='); i >= 0 {
+		l.key = strings.TrimSpace(text[:i])
+		l.value = strings.TrimSuffix(text[i+1:], "\r")
+	}
+	return l
+}
+
+// ParseString is a shortcut for Parse(strings.NewReader(s)).
+func ParseString(s string) (*Config, error) {
+	return Parse(strings.NewReader(s))
+}
+
+// WriteTo writes the configuration in its original order. Unmodified
+// lines (including shadowed duplicates) are written verbatim.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for i, l := range c.lines {
+		text := l.String()
+		if i < len(c.lines)-1 || !c.noEOL {
+			text += "\n"
+		}
+		n, err := io.WriteString(w, text)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// String returns the serialized configuration.
+func (c *Config) String() string {
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.String()
+}
+
+// Get returns the value of a key.
+func (c *Config) Get(key string) (string, bool) {
+	if l, ok := c.index[key]; ok {
+		return l.value, true
+	}
+	return "", false
+}
+
+// Int returns the value of a key as integer.
+func (c *Config) Int(key string) (int, error) {
+	v, ok := c.Get(key)
+	if !ok {
+		return 0, fmt.Errorf("key %s not found", key)
+	}
+	return strconv.Atoi(v)
+}
+
+// Bool returns the value of a key as boolean. AirOS uses "enabled" and
+// "disabled" for most flags.
+func (c *Config) Bool(key string) (bool, error) {
+	v, ok := c.Get(key)
+	if !ok {
+		return false, fmt.Errorf("key %s not found", key)
+	}
+	switch strings.ToLower(v) {
+	case "enabled", "true", "on", "yes", "1":
+		return true, nil
+	case "disabled", "false", "off", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("key %s: invalid boolean %q", key, v)
+}
+
+// Set changes the value of a key. New keys are inserted after the last
+// key of the same section (or appended at the end).
+func (c *Config) Set(key, value string) {
+	if l, ok := c.index[key]; ok {
+		if l.value != value {
+			l.value, l.dirty = value, true
+		}
+		return
+	}
+
+	l := &line{key: key, value: value, dirty: true}
+	c.index[key] = l
+
+	pos := len(c.lines)
+	for prefix := parent(key); prefix != ""; prefix = parent(prefix) {
+		if i := c.lastIndexWithPrefix(prefix + "."); i >= 0 {
+			pos = i + 1
+			break
+		}
+	}
+	c.lines = append(c.lines, nil)
+	copy(c.lines[pos+1:], c.lines[pos:])
+	c.lines[pos] = l
+}
+
+// Delete removes a key (and all its duplicates). It returns false, if
+// the key didn't exist.
+func (c *Config) Delete(key string) bool {
+	if _, ok := c.index[key]; !ok {
+		return false
+	}
+	delete(c.index, key)
+
+	lines := c.lines[:0]
+	for _, l := range c.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+	c.lines = lines
+	return true
+}
+
+// DeleteSection removes all keys starting with prefix + ".". It returns
+// the number of removed keys.
+func (c *Config) DeleteSection(prefix string) (n int) {
+	for _, key := range c.Keys() {
+		if strings.HasPrefix(key, prefix+".") && c.Delete(key) {
+			n++
+		}
+	}
+	return
+}
+
+// Merge sets all keys of other in c.
+func (c *Config) Merge(other *Config) {
+	for _, l := range other.lines {
+		if l.isValue() && other.index[l.key] == l {
+			c.Set(l.key, l.value)
+		}
+	}
+}
+
+// Keys returns all keys in file order.
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, len(c.index))
+	for _, l := range c.lines {
+		if l.isValue() && c.index[l.key] == l {
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// Map returns all key/value pairs.
+func (c *Config) Map() map[string]string {
+	m := make(map[string]string, len(c.index))
+	for key, l := range c.index {
+		m[key] = l.value
+	}
+	return m
+}
+
+// Section returns the key/value pairs below prefix, with the prefix
+// (and separating dot) removed from the keys. For example,
+// Section("radio.1") contains "status" and "channel".
+func (c *Config) Section(prefix string) map[string]string {
+	m := make(map[string]string)
+	for key, l := range c.index {
+		if strings.HasPrefix(key, prefix+".") {
+			m[key[len(prefix)+1:]] = l.value
+		}
+	}
+	return m
+}
+
+// Indices lists the indices of an indexed section in ascending order,
+// e.g. Indices("vlan") returns [1 2] for "vlan.1.*" and "vlan.2.*".
+func (c *Config) Indices(prefix string) []int {
+	seen := make(map[int]bool)
+	for key := range c.index {
+		if !strings.HasPrefix(key, prefix+".") {
+			continue
+		}
+		elem := strings.SplitN(key[len(prefix)+1:], ".", 2)[0]
+		if i, err := strconv.Atoi(elem); err == nil {
+			seen[i] = true
+		}
+	}
+
+	list := make([]int, 0, len(seen))
+	for i := range seen {
+		list = append(list, i)
+	}
+	sort.Ints(list)
+	return list
+}
+
+// Node is an element of the tree representation of a configuration.
+type Node struct {
+	Name     string // path element, e.g. "radio" or "1"
+	Value    string // only set for leaves
+	Children []*Node
+}
+
+// Index returns the numeric value of Name, or -1 if it isn't numeric
+// (i.e. the node isn't an element of an indexed section).
+func (n *Node) Index() int {
+	if i, err := strconv.Atoi(n.Name); err == nil {
+		return i
+	}
+	return -1
+}
+
+// Child returns the child node with the given name (or nil).
+func (n *Node) Child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Tree converts the keys into a tree. Children appear in file order.
+func (c *Config) Tree() *Node {
+	root := &Node{}
+	for _, key := range c.Keys() {
+		n := root
+		for _, elem := range strings.Split(key, ".") {
+			child := n.Child(elem)
+			if child == nil {
+				child = &Node{Name: elem}
+				n.Children = append(n.Children, child)
+			}
+			n = child
+		}
+		n.Value = c.index[key].value
+	}
+	return root
+}
+
+func (c *Config) lastIndexWithPrefix(prefix string) int {
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		if l := c.lines[i]; l.isValue() && strings.HasPrefix(l.key, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func parent(key string) string {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
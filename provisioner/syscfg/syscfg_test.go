@@ -0,0 +1,142 @@
+package syscfg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const example = `# generated
+radio.1.status=enabled
+radio.1.channel=36
+radio.countrycode=276
+
+vlan.1.id=10
+vlan.2.id=42
+vlan.2.devname=eth0
+`
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := ParseString(example)
+	assert.NoError(err)
+	assert.Equal(example, c.String())
+	assert.Equal([]string{"radio.1.status", "radio.1.channel", "radio.countrycode", "vlan.1.id", "vlan.2.id", "vlan.2.devname"}, c.Keys())
+}
+
+func TestAccessors(t *testing.T) {
+	assert := assert.New(t)
+
+	c, _ := ParseString(example)
+
+	v, ok := c.Get("vlan.2.id")
+	assert.True(ok)
+	assert.Equal("42", v)
+	_, ok = c.Get("vlan.3.id")
+	assert.False(ok)
+
+	i, err := c.Int("radio.1.channel")
+	assert.NoError(err)
+	assert.Equal(36, i)
+
+	b, err := c.Bool("radio.1.status")
+	assert.NoError(err)
+	assert.True(b)
+	_, err = c.Bool("vlan.1.id")
+	assert.EqualError(err, `key vlan.1.id: invalid boolean "10"`)
+
+	assert.Equal([]int{1, 2}, c.Indices("vlan"))
+	assert.Equal(map[string]string{"id": "42", "devname": "eth0"}, c.Section("vlan.2"))
+
+	tree := c.Tree()
+	vlan := tree.Child("vlan")
+	if assert.NotNil(vlan) && assert.Len(vlan.Children, 2) {
+		assert.Equal(2, vlan.Children[1].Index())
+		assert.Equal("eth0", vlan.Children[1].Child("devname").Value)
+	}
+	assert.Equal(-1, vlan.Index())
+}
+
+func TestModify(t *testing.T) {
+	assert := assert.New(t)
+
+	c, _ := ParseString(example)
+	c.Set("radio.1.channel", "40")
+	c.Set("radio.1.chanbw", "20")
+	c.Set("sshd.port", "22")
+	assert.True(c.Delete("vlan.1.id"))
+	assert.False(c.Delete("vlan.1.id"))
+	assert.Equal(2, c.DeleteSection("vlan.2"))
+
+	assert.Equal(`# generated
+radio.1.status=enabled
+radio.1.channel=40
+radio.1.chanbw=20
+radio.countrycode=276
+
+sshd.port=22
+`, c.String())
+
+	patch, _ := ParseString("sshd.port=2222\nvlan.1.id=5\n")
+	c.Merge(patch)
+	v, _ := c.Get("sshd.port")
+	assert.Equal("2222", v)
+	assert.Equal([]int{1}, c.Indices("vlan"))
+}
+
+func TestDuplicates(t *testing.T) {
+	assert := assert.New(t)
+
+	c, _ := ParseString("a.b=1\nc=2\na.b=3\n")
+	v, _ := c.Get("a.b")
+	assert.Equal("3", v)
+	assert.Equal([]string{"c", "a.b"}, c.Keys())
+	assert.Equal("a.b=1\nc=2\na.b=3\n", c.String())
+
+	c.Set("a.b", "4")
+	assert.Equal("a.b=1\nc=2\na.b=4\n", c.String())
+}
+
+func TestRoundTripVerbatim(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, input := range []string{
+		"",
+		"\n",
+		"a=1",
+		" a = 1 \n\tb=2\t\n",
+		"a=1\r\nb=2\r\n",
+		"a=1\n=x\n# c=3\na=2\na=1\n  \n",
+		"a=" + strings.Repeat("x", 100000) + "\nb=2",
+	} {
+		c, err := ParseString(input)
+		if assert.NoError(err) {
+			assert.Equal(input, c.String())
+		}
+	}
+
+	c, _ := ParseString(" a = 1 \r\nb=2")
+	v, _ := c.Get("a")
+	assert.Equal(" 1 ", v)
+	c.Set("b", "3")
+	assert.Equal(" a = 1 \r\nb=3", c.String())
+	c.Set("a", "x")
+	assert.Equal("a=x\r\nb=3", c.String())
+}
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	running, _ := ParseString("# running\nresolv.host.1.name=old\nradio.1.channel=36\nsshd.port=22\n")
+	desired, _ := ParseString("radio.1.channel=40\nresolv.host.1.name=old\n\nwireless.1.ssid=test\n")
+
+	diff := Compare(running, desired)
+	assert.False(diff.Empty())
+	assert.Equal([]Change{{Key: "wireless.1.ssid", New: "test"}}, diff.Added)
+	assert.Equal([]Change{{Key: "sshd.port", Old: "22"}}, diff.Removed)
+	assert.Equal([]Change{{Key: "radio.1.channel", Old: "36", New: "40"}}, diff.Changed)
+
+	assert.True(Compare(running, running).Empty())
+}
@@ -0,0 +1,237 @@
+package provisioner
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/digineo/goldflags"
+	"gopkg.in/yaml.v2"
+)
+
+// templateExt is appended to template names to find the template file.
+const templateExt = ".cfg.tmpl"
+
+// TemplateOptions configures the generation of system.cfg files from
+// templates.
+type TemplateOptions struct {
+	Directory string `yaml:"directory"` // contains <name>.cfg.tmpl files
+	Variables string `yaml:"variables"` // YAML or CSV file
+}
+
+// templateVars holds the variables used to render templates. The
+// variables of a device are merged in this order (later ones win):
+// defaults, model, group, device. The "template" variable selects the
+// template, the "group" variable the group.
+type templateVars struct {
+	Defaults map[string]string            `yaml:"defaults"`
+	Models   map[string]map[string]string `yaml:"models"`
+	Groups   map[string]map[string]string `yaml:"groups"`
+	Devices  map[string]map[string]string `yaml:"devices"` // keyed by MAC address
+}
+
+// configTemplates renders device configurations.
+type configTemplates struct {
+	dir  string
+	vars *templateVars
+}
+
+// TemplateData is passed to the templates. Vars holds the merged
+// variables of the device.
+type TemplateData struct {
+	MacAddress string // "00:11:22:aa:bb:cc"
+	MacPlain   string // "001122aabbcc"
+	Hostname   string
+	Model      string
+	Platform   string
+	Firmware   string
+	IPAddress  string
+	Vars       map[string]string
+}
+
+// loadTemplates checks the template directory and reads the variables
+// file.
+func loadTemplates(opts TemplateOptions, base string) (*configTemplates, []error) {
+	if opts.Directory == "" {
+		return nil, nil
+	}
+	if errs := checkDirectory("templates.directory", base, &opts.Directory); len(errs) > 0 {
+		return nil, errs
+	}
+
+	t := &configTemplates{dir: opts.Directory, vars: &templateVars{}}
+	if opts.Variables == "" {
+		return t, nil
+	}
+
+	path, err := goldflags.ExpandPath(opts.Variables, base)
+	if err != nil {
+		return nil, []error{fmt.Errorf("templates.variables: %v", err)}
+	}
+	vars, err := readTemplateVars(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("templates.variables: %v", err)}
+	}
+	t.vars = vars
+	return t, nil
+}
+
+// readTemplateVars reads a variables file. YAML files must have the
+// structure of templateVars. CSV files must have a header row with a
+// "mac" column, all other columns are variables. The special MAC values
+// "default" and "group:<name>" define defaults and group variables.
+func readTemplateVars(path string) (*templateVars, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := &templateVars{}
+	if strings.HasSuffix(path, ".csv") {
+		err = vars.parseCSV(content)
+	} else {
+		err = yaml.Unmarshal(content, vars)
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars.normalize()
+	return vars, nil
+}
+
+// normalize converts the MAC addresses into the format of sanitizeMac.
+func (v *templateVars) normalize() {
+	devices := make(map[string]map[string]string, len(v.Devices))
+	for mac, vars := range v.Devices {
+		devices[sanitizeMac(mac)] = vars
+	}
+	v.Devices = devices
+}
+
+func (v *templateVars) parseCSV(content []byte) error {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	header, macCol := records[0], -1
+	for i, name := range header {
+		if strings.TrimSpace(name) == "mac" {
+			macCol = i
+		}
+	}
+	if macCol < 0 {
+		return fmt.Errorf("missing column \"mac\"")
+	}
+
+	v.Groups = make(map[string]map[string]string)
+	v.Devices = make(map[string]map[string]string)
+	for _, row := range records[1:] {
+		values := make(map[string]string)
+		for i, value := range row {
+			if i != macCol && value != "" {
+				values[strings.TrimSpace(header[i])] = value
+			}
+		}
+
+		switch key := strings.TrimSpace(row[macCol]); {
+		case key == "default":
+			v.Defaults = values
+		case strings.HasPrefix(key, "group:"):
+			v.Groups[strings.TrimPrefix(key, "group:")] = values
+		default:
+			v.Devices[key] = values
+		}
+	}
+	return nil
+}
+
+// lookup merges the variables of a device.
+func (v *templateVars) lookup(mac, model string) map[string]string {
+	merged := make(map[string]string)
+	merge := func(m map[string]string) {
+		for k, val := range m {
+			merged[k] = val
+		}
+	}
+
+	device := v.Devices[sanitizeMac(mac)]
+	group := device["group"]
+	if group == "" {
+		group = v.Models[model]["group"]
+	}
+	if group == "" {
+		group = v.Defaults["group"]
+	}
+
+	merge(v.Defaults)
+	merge(v.Models[model])
+	merge(v.Groups[group])
+	merge(device)
+	return merged
+}
+
+// templateFor returns the name of the template used for a device, or
+// an empty string, if there is none.
+func (t *configTemplates) templateFor(mac, model string) string {
+	if t == nil {
+		return ""
+	}
+	name := t.vars.lookup(mac, model)["template"]
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(t.dir, name+templateExt)); err != nil {
+		return ""
+	}
+	return name
+}
+
+// render executes the template of a device.
+func (t *configTemplates) render(d *Device, ipAddress string) ([]byte, error) {
+	snap := d.Snapshot()
+	name := t.templateFor(snap.MacAddress, snap.Model)
+	if name == "" {
+		return nil, fmt.Errorf("no template for device %s", snap.MacAddress)
+	}
+
+	tpl, err := t.parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return execute(tpl, &TemplateData{
+		MacAddress: snap.MacAddress,
+		MacPlain:   sanitizeMac(snap.MacAddress),
+		Hostname:   snap.Hostname,
+		Model:      snap.Model,
+		Platform:   snap.Platform,
+		Firmware:   snap.Firmware,
+		IPAddress:  ipAddress,
+		Vars:       t.vars.lookup(snap.MacAddress, snap.Model),
+	})
+}
+
+// parse reads the template with the given name. Rendering and
+// CheckConfig both use it, so they agree on syntax and options.
+func (t *configTemplates) parse(name string) (*template.Template, error) {
+	return template.New(name + templateExt).
+		Option("missingkey=error").
+		ParseFiles(filepath.Join(t.dir, name+templateExt))
+}
+
+func execute(tpl *template.Template, data *TemplateData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
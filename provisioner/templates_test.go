@@ -0,0 +1,66 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateVars(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := &templateVars{}
+	assert.NoError(vars.parseCSV([]byte("mac,template,group,ssid\ndefault,ap,,default-ssid\ngroup:ptp,ptp,,\n00:11:22:AA:BB:CC,,ptp,\n")))
+	vars.normalize()
+
+	v := vars.lookup("00:11:22:aa:bb:cc", "")
+	assert.Equal("ptp", v["template"])
+	assert.Equal("default-ssid", v["ssid"])
+
+	v = vars.lookup("00:00:00:00:00:01", "")
+	assert.Equal("ap", v["template"])
+
+	assert.EqualError(vars.parseCSV([]byte("a,b\n1,2\n")), `missing column "mac"`)
+}
+
+func TestTemplateRender(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "ap"+templateExt), []byte("system.hostname={{.Vars.hostname}}\nwireless.1.ssid={{.Vars.ssid}}\n# {{.MacPlain}}\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "vars.yml"), []byte(`
+defaults:
+  template: ap
+  ssid: fallback
+models:
+  "LiteBeam 5AC":
+    ssid: litebeam
+devices:
+  "00:11:22:AA:BB:CC":
+    hostname: ap-1
+`), 0644))
+
+	tpl, errs := loadTemplates(TemplateOptions{Directory: dir, Variables: "./vars.yml"}, dir)
+	if !assert.Empty(errs) {
+		return
+	}
+
+	dev := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", Model: "LiteBeam 5AC"})
+	assert.Equal("ap", tpl.templateFor(dev.MacAddress, dev.Model))
+
+	out, err := tpl.render(dev, "192.168.1.1")
+	assert.NoError(err)
+	assert.Equal("system.hostname=ap-1\nwireless.1.ssid=litebeam\n# 001122aabbcc\n", string(out))
+
+	// missing variable
+	other := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cd"})
+	_, err = tpl.render(other, "")
+	assert.Error(err)
+
+	var none *configTemplates
+	assert.Equal("", none.templateFor(dev.MacAddress, dev.Model))
+}
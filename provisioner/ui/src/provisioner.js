@@ -10,9 +10,13 @@ class Provisioner {
     this.numDevices   = 0
     this.devices      = {}
     this.alerts       = []
+    this.diffs        = {}
+    this.progress     = {}
+    this.live         = false
 
     this.getDevices()
     this.startRefresh()
+    this.subscribe()
   }
 
   get refreshRate() { return this._refreshRate }
@@ -33,6 +37,9 @@ class Provisioner {
   }
 
   refreshHuman() {
+    if (this.live) {
+      return "live"
+    }
     if (!this.refreshRate) {
       return "off"
     }
@@ -85,6 +92,68 @@ class Provisioner {
     return
   }
 
+  updateDevice(device) {
+    let devices = Object.assign({}, this.devices)
+    devices[device.mac_address] = device
+    this.numDevices = Object.keys(devices).length
+    this.devices = devices
+  }
+
+  // subscribe listens for server-sent events. While connected, polling
+  // is suspended.
+  subscribe() {
+    if (!window.EventSource || !hasProp.call(this.urlDirectory, "events")) {
+      return
+    }
+
+    let source = new EventSource(this.url("events"))
+    source.onopen = () => {
+      this.live = true
+      this.stopRefresh()
+      this.getDevices()
+    }
+    source.onerror = () => {
+      if (this.live) {
+        this.live = false
+        this.startRefresh()
+      }
+    }
+
+    let onDevice = (e) => this.updateDevice(JSON.parse(e.data).device)
+    source.addEventListener("device-added", onDevice)
+    source.addEventListener("device-changed", onDevice)
+    source.addEventListener("device-status", onDevice)
+    source.addEventListener("device-lost", (e) => {
+      let data = JSON.parse(e.data)
+      this.updateDevice(data.device)
+      this.log("warning", `Device ${data.device.mac_address} lost.`)
+    })
+    source.addEventListener("job-progress", (e) => {
+      let job = JSON.parse(e.data).job
+      this.progress = Object.assign({}, this.progress, {[job.mac_address]: job.progress})
+    })
+    source.addEventListener("job", (e) => {
+      let job = JSON.parse(e.data).job
+      if (job.finished_at && hasProp.call(this.progress, job.mac_address)) {
+        let progress = Object.assign({}, this.progress)
+        delete progress[job.mac_address]
+        this.progress = progress
+      }
+      if (job.state === "succeeded") {
+        this.log("success", `Job ${job.id} (${job.type} ${job.mac_address}) succeeded.`)
+      } else if (job.state === "failed") {
+        this.log("danger", `Job ${job.id} (${job.type} ${job.mac_address}) failed: ${job.error}`)
+      }
+    })
+    source.addEventListener("config-reload", (e) => {
+      this.log("info", JSON.parse(e.data).message)
+    })
+    source.addEventListener("alert", (e) => {
+      let data = JSON.parse(e.data)
+      this.log("danger", data.device ? `${data.device.mac_address}: ${data.message}` : data.message)
+    })
+  }
+
   log(type, message) {
     this.alerts.unshift({ t: moment().unix(), style: `alert-${type}`, message: message })
     this.alerts.splice(5) // keep 5
@@ -92,7 +161,7 @@ class Provisioner {
 
   startRefresh() {
     this.stopRefresh()
-    if (this.refreshRate) {
+    if (this.refreshRate && !this.live) {
       this._refreshID = setInterval(() => this.getDevices(), this.refreshRate)
     }
   }
@@ -104,6 +173,48 @@ class Provisioner {
     }
   }
 
+  // getConfigDiff fetches the changes provisioning would apply to the
+  // device. The result is stored in this.diffs until provisioning starts
+  // or clearConfigDiff is called.
+  getConfigDiff(mac) {
+    let promise = jQuery.getJSON(this.url("device_config_diff", {mac: mac}))
+    promise.done((data, _status, _xhr) => {
+      this.diffs = Object.assign({}, this.diffs, {[mac]: data})
+    })
+    promise.fail((xhr, status, error) => {
+      let data = xhr.responseJSON
+      if (data && data.type && data.message) {
+        this.log(data.type, data.message)
+      } else {
+        this.log("danger", `Fetching configuration diff for device ${mac} failed (${status || error})`)
+      }
+    })
+  }
+
+  clearConfigDiff(mac) {
+    let diffs = Object.assign({}, this.diffs)
+    delete diffs[mac]
+    this.diffs = diffs
+  }
+
+  reloadConfig() {
+    let promise = jQuery.ajax({ url: this.url("reload_config"), method: "POST" })
+    promise.done((data, _status, _xhr) => {
+      if (!this.live) {
+        this.log(data.type, data.message)
+      }
+    })
+    promise.fail((xhr, status, error) => {
+      let data = xhr.responseJSON
+      if (data && data.errors) {
+        data.errors.forEach((e) => this.log("danger", e))
+      }
+      if (!this.live) {
+        this.log("danger", (data && data.message) || `Reloading configuration failed (${status || error})`)
+      }
+    })
+  }
+
   deviceAction(action, mac) {
     let dev = this.devices[mac]
     let url = null
@@ -111,6 +222,33 @@ class Provisioner {
       this.log("danger", `Device ${mac} not found.`)
       return
     }
+    if (action === "diff") {
+      this.getConfigDiff(mac)
+      return
+    }
+    if (action === "check-access") {
+      let promise = jQuery.ajax({ url: this.url("check_access", {mac: mac}), method: "POST" })
+      promise.done((data, _status, _xhr) => {
+        let level = data.status === "auth OK" ? "success" : "warning"
+        this.log(level, `SSH access to ${mac}: ${data.status}` + (data.error ? ` (${data.error})` : ""))
+      })
+      promise.fail((xhr, status, error) => {
+        let data = xhr.responseJSON
+        this.log("danger", (data && data.message) || `Checking access to ${mac} failed (${status || error})`)
+      })
+      return
+    }
+    if (action === "forget-host-key") {
+      let promise = jQuery.ajax({ url: this.url("forget_host_key", {mac: mac}), method: "DELETE" })
+      promise.done((data, _status, _xhr) => {
+        this.log(data.type, data.message)
+      })
+      promise.fail((xhr, status, error) => {
+        let data = xhr.responseJSON
+        this.log("danger", (data && data.message) || `Forgetting host key of ${mac} failed (${status || error})`)
+      })
+      return
+    }
     if (["reboot", "provision", "upgrade"].indexOf(action) >= 0) {
       if (!(url = this.url(`${action}_device`, {mac: mac}))) {
         this.log("danger", `Don't know how to perform ${action} action: Missing route.`)
@@ -124,6 +262,9 @@ class Provisioner {
       this.log("danger", `No configuration found for device ${mac}.`)
       return
     }
+    if (action === "provision") {
+      this.clearConfigDiff(mac)
+    }
     if (action === "upgrade" && !dev.can_upgrade) {
       this.log("danger", `No firmware upgrade found for device ${mac}.`)
       return
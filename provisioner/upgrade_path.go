@@ -0,0 +1,127 @@
+package provisioner
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/digineo/ubnt-tools/provisioner/firmware"
+)
+
+// upgradeStep is a single hop of an upgrade path.
+type upgradeStep struct {
+	Image    string // path to firmware image
+	Firmware string // firmware version reported by the device afterwards
+}
+
+// upgradeGraph treats the safe_upgrade_paths as directed graph, where
+// each firmware version reported by a device has edges to the images it
+// can safely be upgraded to.
+type upgradeGraph struct {
+	edges map[string][]string // firmware version -> image names
+}
+
+func newUpgradeGraph(safeUpgradePaths map[string][]string) *upgradeGraph {
+	g := &upgradeGraph{edges: make(map[string][]string)}
+	for image, sources := range safeUpgradePaths {
+		for _, source := range sources {
+			g.edges[source] = append(g.edges[source], image)
+		}
+	}
+	return g
+}
+
+// plan computes the shortest chain of upgrades from the given firmware
+// version to the newest reachable one. Images unknown to the images
+// function (i.e. it returns nil) or incompatible with the device end the
+// chain prematurely.
+func (g *upgradeGraph) plan(current, dir string, images func(name string) *firmware.Image) []upgradeStep {
+	type node struct {
+		prev *node
+		step upgradeStep
+	}
+
+	var best *node
+	visited := map[string]bool{current: true}
+	queue := []*node{{step: upgradeStep{Firmware: current}}}
+
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+
+		for _, image := range g.edges[curr.step.Firmware] {
+			img := images(image)
+			if img == nil || img.Compatible(curr.step.Firmware) != nil {
+				continue
+			}
+			next := predictFirmware(curr.step.Firmware, img.Version)
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+
+			n := &node{prev: curr, step: upgradeStep{Image: filepath.Join(dir, image), Firmware: next}}
+			if best == nil || compareFirmware(next, best.step.Firmware) > 0 {
+				best = n
+			}
+			queue = append(queue, n)
+		}
+	}
+
+	var steps []upgradeStep
+	for n := best; n != nil && n.prev != nil; n = n.prev {
+		steps = append([]upgradeStep{n.step}, steps...)
+	}
+	return steps
+}
+
+// splitFirmware separates a firmware identifier into its prefix (board
+// family and optionally platform, e.g. "XC.qca955x") and version (e.g.
+// "v8.1.4.34481.170608.1728"). Image names ("XC.v8.1.4.34481.170608.1728.bin")
+// are handled as well.
+func splitFirmware(name string) (prefix, version string) {
+	name = strings.TrimSuffix(name, ".bin")
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		if len(p) > 1 && p[0] == 'v' && p[1] >= '0' && p[1] <= '9' {
+			return strings.Join(parts[:i], "."), strings.Join(parts[i:], ".")
+		}
+	}
+	return name, ""
+}
+
+// predictFirmware returns the firmware version a device will report after
+// it has been upgraded from the current firmware with the given image.
+func predictFirmware(current, image string) string {
+	curPrefix, _ := splitFirmware(current)
+	imgPrefix, imgVersion := splitFirmware(image)
+	if imgVersion == "" {
+		return imgPrefix
+	}
+
+	// keep platform information (e.g. "qca955x"), which isn't part of
+	// the image name
+	if i := strings.Index(curPrefix, "."); i >= 0 && !strings.Contains(imgPrefix, ".") {
+		imgPrefix += curPrefix[i:]
+	}
+	return imgPrefix + "." + imgVersion
+}
+
+// compareFirmware compares the numeric version components of two firmware
+// identifiers. The result is negative, if a < b, positive if a > b and 0
+// otherwise.
+func compareFirmware(a, b string) int {
+	_, va := splitFirmware(a)
+	_, vb := splitFirmware(b)
+	na := strings.Split(strings.TrimPrefix(va, "v"), ".")
+	nb := strings.Split(strings.TrimPrefix(vb, "v"), ".")
+
+	for i := 0; i < len(na) && i < len(nb); i++ {
+		x, _ := strconv.Atoi(na[i])
+		y, _ := strconv.Atoi(nb[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return len(na) - len(nb)
+}
@@ -0,0 +1,91 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/discovery"
+	"github.com/digineo/ubnt-tools/provisioner/firmware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitFirmware(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := map[string][2]string{
+		"XC.qca955x.v8.0.2.33352.170327.1907": {"XC.qca955x", "v8.0.2.33352.170327.1907"},
+		"XC.v8.1.4.34481.170608.1728.bin":     {"XC", "v8.1.4.34481.170608.1728"},
+		"EdgeRouter.ER-e100.v1.9.1.4939093":   {"EdgeRouter.ER-e100", "v1.9.1.4939093"},
+		"foobar":                              {"foobar", ""},
+	}
+	for name, expected := range tt {
+		prefix, version := splitFirmware(name)
+		assert.Equal(expected[0], prefix, name)
+		assert.Equal(expected[1], version, name)
+	}
+}
+
+func TestCompareFirmware(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(compareFirmware("XC.qca955x.v8.1.4.34481.170608.1728", "XC.v7.2.4.31259.160714.1715.bin") > 0)
+	assert.True(compareFirmware("XC.v7.2.4.31259.160714.1715.bin", "XC.qca955x.v7.10.0.1.1.1") < 0)
+	assert.Equal(0, compareFirmware("XC.v8.1.4.bin", "XC.qca955x.v8.1.4"))
+}
+
+func TestUpgradeGraphPlan(t *testing.T) {
+	assert := assert.New(t)
+
+	images := func(name string) *firmware.Image {
+		version := map[string]string{
+			"XC.v7.2.4.31259.160714.1715.bin": "XC.qca955x.v7.2.4.31259.160714.1715",
+			"XC.v8.1.4.34481.170608.1728.bin": "XC.qca955x.v8.1.4.34481.170608.1728",
+			"XW.v8.1.4.34481.170608.1728.bin": "XW.ar934x.v8.1.4.34481.170608.1728",
+		}[name]
+		if version == "" {
+			return nil
+		}
+		return &firmware.Image{Version: version, Board: version[:2]}
+	}
+
+	g := newUpgradeGraph(map[string][]string{
+		"XC.v7.2.4.31259.160714.1715.bin": {"XC.qca955x.v6.0.0.1.1.1", "XC.qca955x.v7.2.1.30741.160412.1342"},
+		"XC.v8.1.4.34481.170608.1728.bin": {"XC.qca955x.v7.2.4.31259.160714.1715", "XC.qca955x.v8.0.2.33352.170327.1907"},
+		"XC.v8.2.0.1.1.1.bin":             {"XC.qca955x.v8.1.4.34481.170608.1728"}, // missing
+		"XW.v8.1.4.34481.170608.1728.bin": {"XC.qca955x.v8.0.2.33352.170327.1907"}, // incompatible
+	})
+
+	steps := g.plan("XC.qca955x.v6.0.0.1.1.1", "/fw", images)
+	if assert.Len(steps, 2) {
+		assert.Equal("/fw/XC.v7.2.4.31259.160714.1715.bin", steps[0].Image)
+		assert.Equal("XC.qca955x.v7.2.4.31259.160714.1715", steps[0].Firmware)
+		assert.Equal("/fw/XC.v8.1.4.34481.170608.1728.bin", steps[1].Image)
+		assert.Equal("XC.qca955x.v8.1.4.34481.170608.1728", steps[1].Firmware)
+	}
+
+	steps = g.plan("XC.qca955x.v8.0.2.33352.170327.1907", "/fw", images)
+	if assert.Len(steps, 1) {
+		assert.Equal("/fw/XC.v8.1.4.34481.170608.1728.bin", steps[0].Image)
+	}
+	assert.Empty(g.plan("XC.qca955x.v8.1.4.34481.170608.1728", "/fw", images))
+	assert.Empty(g.plan("unknown", "/fw", images))
+}
+
+func TestVerifyUpgrade(t *testing.T) {
+	assert := assert.New(t)
+
+	d := newDevice(&discovery.Device{MacAddress: "00:11:22:aa:bb:cc", Firmware: "XC.v8.0.2"})
+	d.markReboot(time.Hour)
+	assert.Equal("rebooting", d.Status())
+
+	d.merge(&discovery.Device{MacAddress: d.MacAddress, Firmware: "XC.v8.1.4", LastSeenAt: time.Now().Add(2 * time.Hour)})
+	assert.Equal("idle", d.Status())
+
+	result, err := d.verifyUpgrade("XC.v8.1.4", time.Second)
+	assert.Equal(UpgradeSucceeded, result)
+	assert.NoError(err)
+
+	result, err = d.verifyUpgrade("XC.v8.2.0", time.Second)
+	assert.Equal(UpgradeMismatch, result)
+	assert.EqualError(err, "Device returned with firmware XC.v8.1.4, expected XC.v8.2.0")
+}
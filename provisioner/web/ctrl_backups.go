@@ -0,0 +1,66 @@
+package web
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// GET /api/devices/{mac}/backups
+func (g *goWeb) getBackups(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	backups, err := dev.Backups()
+	if err != nil {
+		g.statusJSON(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusOK, WrapBackupJSON(backups))
+}
+
+// GET /api/devices/{mac}/backups/{name}
+func (g *goWeb) getBackup(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	name := mux.Vars(r)["name"]
+	path, err := dev.BackupPath(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			g.statusJSON(w, http.StatusNotFound, "Unknown backup.")
+		} else {
+			g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		}
+		return
+	}
+
+	w.Header().Set(headerContentType, contentTypeText)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, path)
+}
+
+// POST /api/devices/{mac}/backups/{name}/restore
+func (g *goWeb) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	name := mux.Vars(r)["name"]
+	job, err := dev.Restore(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			g.statusJSON(w, http.StatusNotFound, "Unknown backup.")
+		} else {
+			g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		}
+		return
+	}
+	g.jobJSON(w, job, "Restore of backup %s for %s enqueued (job %d).", name, dev.MacAddress, job.ID)
+}
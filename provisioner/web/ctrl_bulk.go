@@ -0,0 +1,131 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+	"github.com/gorilla/mux"
+)
+
+// bulkRequest is the payload for POST /api/bulk/{action}. Either a list
+// of MAC addresses, a non-empty filter or all=true must be given. A
+// max_failures of 0 disables the threshold, if missing the configured
+// default applies.
+type bulkRequest struct {
+	Devices     []string                  `json:"devices"`
+	Filter      *provisioner.DeviceFilter `json:"filter"`
+	All         bool                      `json:"all"`
+	Concurrency int                       `json:"concurrency"`
+	MaxFailures *int                      `json:"max_failures"`
+	BatchDelay  string                    `json:"batch_delay"` // e.g. "30s"
+}
+
+// options converts the request into BulkOptions.
+func (req *bulkRequest) options() (opts provisioner.BulkOptions, err error) {
+	opts.Concurrency = req.Concurrency
+	opts.MaxFailures = req.MaxFailures
+	if req.BatchDelay != "" {
+		if opts.BatchDelay, err = time.ParseDuration(req.BatchDelay); err != nil {
+			err = fmt.Errorf("Invalid batch_delay: %v", err)
+		}
+	}
+	return
+}
+
+// GET /api/bulk
+func (g *goWeb) getBulks(w http.ResponseWriter, r *http.Request) {
+	g.responseJSON(w, http.StatusOK, WrapBulkJSON(g.config.GetBulks()))
+}
+
+// GET /api/bulk/{id}
+func (g *goWeb) getBulk(w http.ResponseWriter, r *http.Request) {
+	if b := g.findBulk(r); b != nil {
+		g.responseJSON(w, http.StatusOK, MakeBulkJSON(b))
+	} else {
+		g.statusJSON(w, http.StatusNotFound, "Unknown bulk operation.")
+	}
+}
+
+// DELETE /api/bulk/{id}
+func (g *goWeb) cancelBulk(w http.ResponseWriter, r *http.Request) {
+	b := g.findBulk(r)
+	if b == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown bulk operation.")
+		return
+	}
+	if err := b.Cancel(); err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.statusJSON(w, http.StatusOK, "Bulk operation %d cancelled.", b.ID)
+}
+
+// POST /api/bulk/{action}
+func (g *goWeb) startBulk(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "Invalid request: %v", err)
+		return
+	}
+
+	devices, ok := g.selectDevices(w, &req)
+	if !ok {
+		return
+	}
+
+	opts, err := req.options()
+	if err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	b, err := g.config.StartBulk(action, devices, opts)
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusAccepted, map[string]interface{}{
+		"type":    "success",
+		"message": fmt.Sprintf("Bulk %s for %d device(s) started.", action, len(devices)),
+		"bulk_id": b.ID,
+	})
+}
+
+// selectDevices resolves a list of MAC addresses, a filter or all=true
+// into a list of devices. Unknown MAC addresses and empty filters are
+// rejected. On error, a response is written and ok is false.
+func (g *goWeb) selectDevices(w http.ResponseWriter, req *bulkRequest) (devices []*provisioner.Device, ok bool) {
+	switch {
+	case len(req.Devices) > 0:
+		for _, mac := range req.Devices {
+			dev := g.config.FindDevice(mac)
+			if dev == nil {
+				g.statusJSON(w, http.StatusNotFound, "Unknown device %s.", mac)
+				return nil, false
+			}
+			devices = append(devices, dev)
+		}
+	case req.Filter != nil && !req.Filter.Empty():
+		devices = g.config.FilterDevices(req.Filter)
+	case req.All:
+		devices = g.config.FilterDevices(&provisioner.DeviceFilter{})
+	default:
+		g.statusJSON(w, http.StatusBadRequest, "Either devices, a non-empty filter or all must be given.")
+		return nil, false
+	}
+	return devices, true
+}
+
+func (g *goWeb) findBulk(r *http.Request) *provisioner.Bulk {
+	vars := mux.Vars(r)
+	if id, err := strconv.ParseUint(vars["id"], 10, 64); err == nil {
+		return g.config.FindBulk(id)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package web
+
+import "net/http"
+
+// POST /api/config/reload
+func (g *goWeb) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	errs := g.config.Reload()
+	if len(errs) == 0 {
+		g.statusJSON(w, http.StatusOK, "Configuration reloaded.")
+		return
+	}
+
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	g.responseJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"type":    "danger",
+		"message": "Reloading configuration failed, keeping the current one.",
+		"errors":  msgs,
+	})
+}
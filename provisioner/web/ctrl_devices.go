@@ -1,7 +1,9 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/digineo/ubnt-tools/provisioner"
 	"github.com/gorilla/mux"
@@ -29,11 +31,12 @@ func (g *goWeb) upgradeDevice(w http.ResponseWriter, r *http.Request) {
 		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
 		return
 	}
-	if err := dev.Upgrade(); err != nil {
+	job, err := dev.Upgrade()
+	if err != nil {
 		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
 		return
 	}
-	g.statusJSON(w, http.StatusOK, "Firmware upgrade for %s enqueued.", dev.MacAddress)
+	g.jobJSON(w, job, "Firmware upgrade for %s enqueued (job %d).", dev.MacAddress, job.ID)
 }
 
 // POST /api/devices/{mac}/provision
@@ -43,11 +46,12 @@ func (g *goWeb) provisionDevice(w http.ResponseWriter, r *http.Request) {
 		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
 		return
 	}
-	if err := dev.Provision(); err != nil {
+	job, err := dev.Provision()
+	if err != nil {
 		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
 		return
 	}
-	g.statusJSON(w, http.StatusOK, "Provisioning for %s enqueued.", dev.MacAddress)
+	g.jobJSON(w, job, "Provisioning for %s enqueued (job %d).", dev.MacAddress, job.ID)
 }
 
 // POST /api/devices/{mac}/reboot
@@ -57,11 +61,98 @@ func (g *goWeb) rebootDevice(w http.ResponseWriter, r *http.Request) {
 		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
 		return
 	}
-	if err := dev.Reboot(); err != nil {
+	job, err := dev.Reboot()
+	if err != nil {
 		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
 		return
 	}
-	g.statusJSON(w, http.StatusOK, "Rebooting device %s.", dev.MacAddress)
+	g.jobJSON(w, job, "Reboot of device %s enqueued (job %d).", dev.MacAddress, job.ID)
+}
+
+// GET /api/devices/{mac}/config/diff
+func (g *goWeb) getConfigDiff(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	diff, err := dev.ConfigDiff()
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusOK, MakeConfigDiffJSON(diff))
+}
+
+// GET /api/devices/{mac}/config/rendered
+func (g *goWeb) getRenderedConfig(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	content, err := dev.DesiredConfig()
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	w.Header().Set(headerContentType, contentTypeText)
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// GET /api/devices/{mac}/log
+func (g *goWeb) getDeviceLog(w http.ResponseWriter, r *http.Request) {
+	if dev := g.findDevice(r); dev != nil {
+		g.responseJSON(w, http.StatusOK, WrapLogLineJSON(dev.Log()))
+	} else {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+	}
+}
+
+// GET /api/devices/{mac}/log/stream
+func (g *goWeb) streamDeviceLog(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	history, lines, unsubscribe := dev.FollowLog()
+	defer unsubscribe()
+
+	w.Header().Set(headerContentType, contentTypeText)
+	w.WriteHeader(http.StatusOK)
+	for _, line := range history {
+		fmt.Fprintf(w, "%s %s\n", line.Time.Format(time.RFC3339), line.Message)
+	}
+	rc.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-lines:
+			fmt.Fprintf(w, "%s %s\n", line.Time.Format(time.RFC3339), line.Message)
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// POST /api/devices/{mac}/check-access
+func (g *goWeb) checkAccess(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	dev.CheckAccess()
+	g.responseJSON(w, http.StatusOK, MakeAccessJSON(dev))
 }
 
 func (g *goWeb) findDevice(r *http.Request) *provisioner.Device {
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	contentTypeEventStream = "text/event-stream"
+	eventKeepAlive         = 30 * time.Second
+)
+
+// GET /api/events
+func (g *goWeb) getEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	events, unsubscribe := g.config.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set(headerContentType, contentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	rc.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-events:
+			data, err := json.Marshal(MakeEventJSON(e))
+			if err != nil {
+				log.Printf("[getEvents error] %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
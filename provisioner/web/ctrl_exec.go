@@ -0,0 +1,95 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+)
+
+// execRequest is the payload for POST /api/devices/{mac}/exec.
+type execRequest struct {
+	Command string `json:"command"`
+}
+
+// GET /api/exec/commands
+func (g *goWeb) getExecCommands(w http.ResponseWriter, r *http.Request) {
+	commands := g.config.ExecCommands()
+	if commands == nil {
+		commands = []string{}
+	}
+	g.responseJSON(w, http.StatusOK, commands)
+}
+
+// POST /api/devices/{mac}/exec
+func (g *goWeb) execDevice(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "Invalid request: %v", err)
+		return
+	}
+
+	// the exec timeout may exceed the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	res, err := dev.Exec(r.Context(), req.Command)
+	if _, ok := err.(provisioner.ErrCommandNotAllowed); ok {
+		g.statusJSON(w, http.StatusForbidden, "%v", err)
+		return
+	}
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusOK, MakeExecResultJSON(res))
+}
+
+// bulkExecRequest is the payload for POST /api/bulk/exec. Devices are
+// selected as for other bulk operations.
+type bulkExecRequest struct {
+	bulkRequest
+	execRequest
+}
+
+// POST /api/bulk/exec
+func (g *goWeb) startBulkExec(w http.ResponseWriter, r *http.Request) {
+	var req bulkExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "Invalid request: %v", err)
+		return
+	}
+
+	devices, ok := g.selectDevices(w, &req.bulkRequest)
+	if !ok {
+		return
+	}
+
+	opts, err := req.options()
+	if err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	b, err := g.config.StartBulkExec(req.Command, devices, opts)
+	if _, ok := err.(provisioner.ErrCommandNotAllowed); ok {
+		g.statusJSON(w, http.StatusForbidden, "%v", err)
+		return
+	}
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusAccepted, map[string]interface{}{
+		"type":    "success",
+		"message": fmt.Sprintf("Running %q on %d device(s).", req.Command, len(devices)),
+		"bulk_id": b.ID,
+	})
+}
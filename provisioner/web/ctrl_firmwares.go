@@ -0,0 +1,8 @@
+package web
+
+import "net/http"
+
+// GET /api/firmwares
+func (g *goWeb) getFirmwares(w http.ResponseWriter, r *http.Request) {
+	g.responseJSON(w, http.StatusOK, WrapFirmwareJSON(g.config.GetFirmwares()))
+}
@@ -0,0 +1,36 @@
+package web
+
+import "net/http"
+
+// GET /api/host-keys
+func (g *goWeb) getHostKeys(w http.ResponseWriter, r *http.Request) {
+	g.responseJSON(w, http.StatusOK, WrapHostKeyJSON(g.config.KnownHostKeys()))
+}
+
+// GET /api/devices/{mac}/host-key
+func (g *goWeb) getHostKey(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	if key := dev.HostKey(); key != nil {
+		g.responseJSON(w, http.StatusOK, MakeHostKeyJSON(key))
+	} else {
+		g.statusJSON(w, http.StatusNotFound, "No host key known for %s.", dev.MacAddress)
+	}
+}
+
+// DELETE /api/devices/{mac}/host-key
+func (g *goWeb) forgetHostKey(w http.ResponseWriter, r *http.Request) {
+	dev := g.findDevice(r)
+	if dev == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown device.")
+		return
+	}
+	if err := dev.ForgetHostKey(); err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.statusJSON(w, http.StatusOK, "Host key of %s forgotten.", dev.MacAddress)
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+	"github.com/gorilla/mux"
+)
+
+// GET /api/jobs
+func (g *goWeb) getJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := WrapJobJSON(g.config.GetJobs())
+	g.responseJSON(w, http.StatusOK, jobs)
+}
+
+// GET /api/jobs/{id}
+func (g *goWeb) getJob(w http.ResponseWriter, r *http.Request) {
+	if job := g.findJob(r); job != nil {
+		g.responseJSON(w, http.StatusOK, MakeJobJSON(job))
+	} else {
+		g.statusJSON(w, http.StatusNotFound, "Unknown job.")
+	}
+}
+
+// DELETE /api/jobs/{id}
+func (g *goWeb) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job := g.findJob(r)
+	if job == nil {
+		g.statusJSON(w, http.StatusNotFound, "Unknown job.")
+		return
+	}
+	if err := job.Cancel(); err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.statusJSON(w, http.StatusOK, "Job %d cancelled.", job.ID)
+}
+
+func (g *goWeb) findJob(r *http.Request) *provisioner.Job {
+	vars := mux.Vars(r)
+	if id, err := strconv.ParseUint(vars["id"], 10, 64); err == nil {
+		return g.config.FindJob(id)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+)
+
+// patchRequest is the payload for POST /api/patch. Devices are selected
+// as for bulk operations. With dry_run, the per-device changes are
+// returned instead of applied.
+type patchRequest struct {
+	bulkRequest
+	provisioner.ConfigPatch
+	DryRun bool `json:"dry_run"`
+}
+
+// POST /api/patch
+func (g *goWeb) patchDevices(w http.ResponseWriter, r *http.Request) {
+	var req patchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "Invalid request: %v", err)
+		return
+	}
+
+	devices, ok := g.selectDevices(w, &req.bulkRequest)
+	if !ok {
+		return
+	}
+
+	if req.DryRun {
+		// fetching the running configurations may take longer than the
+		// server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		previews, err := g.config.PreviewPatch(devices, &req.ConfigPatch)
+		if err != nil {
+			g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+			return
+		}
+		g.responseJSON(w, http.StatusOK, WrapPatchPreviewJSON(previews))
+		return
+	}
+
+	opts, err := req.options()
+	if err != nil {
+		g.statusJSON(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	b, err := g.config.StartPatch(devices, &req.ConfigPatch, opts)
+	if err != nil {
+		g.statusJSON(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	g.responseJSON(w, http.StatusAccepted, map[string]interface{}{
+		"type":    "success",
+		"message": fmt.Sprintf("Patch for %d device(s) started.", len(devices)),
+		"bulk_id": b.ID,
+	})
+}
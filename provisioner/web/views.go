@@ -1,47 +1,86 @@
 package web
 
-import "github.com/digineo/ubnt-tools/provisioner"
+import (
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+	pssh "github.com/digineo/ubnt-tools/provisioner/ssh"
+	"github.com/digineo/ubnt-tools/provisioner/syscfg"
+)
 
 // DeviceJSON wraps a provisioner.Device into JSON presentation
 type DeviceJSON struct {
-	CanUpgrade   bool                `json:"can_upgrade"`
-	Essid        string              `json:"essid"`
-	Firmware     string              `json:"firmware"`
-	FirstSeenAt  int64               `json:"first_seen_at"`
-	HasConfig    bool                `json:"has_config"`
-	Hostname     string              `json:"hostname"`
-	IPAddress    string              `json:"ip_address"`
-	IPAddresses  map[string][]string `json:"ip_addresses"`
-	LastSeenAt   int64               `json:"last_seen_at"`
-	MacAddress   string              `json:"mac_address"`
-	Model        string              `json:"model"`
-	Platform     string              `json:"platform"`
-	Status       string              `json:"status"`
-	UpSince      int64               `json:"up_since"`
-	WirelessMode string              `json:"wireless_mode"`
+	Access         *AccessJSON         `json:"access,omitempty"`
+	CanUpgrade     bool                `json:"can_upgrade"`
+	ConfigRule     string              `json:"config_rule,omitempty"`
+	ConfigTemplate string              `json:"config_template,omitempty"`
+	Drift          *ConfigDiffJSON     `json:"drift,omitempty"`
+	DriftCheckedAt int64               `json:"drift_checked_at,omitempty"`
+	DriftError     string              `json:"drift_error,omitempty"`
+	Essid          string              `json:"essid"`
+	Firmware       string              `json:"firmware"`
+	FirstSeenAt    int64               `json:"first_seen_at"`
+	HasConfig      bool                `json:"has_config"`
+	HostKey        *HostKeyJSON        `json:"host_key,omitempty"`
+	Hostname       string              `json:"hostname"`
+	IPAddress      string              `json:"ip_address"`
+	IPAddresses    map[string][]string `json:"ip_addresses"`
+	JobID          uint64              `json:"job_id,omitempty"`
+	LastSeenAt     int64               `json:"last_seen_at"`
+	MacAddress     string              `json:"mac_address"`
+	Model          string              `json:"model"`
+	Platform       string              `json:"platform"`
+	Status         string              `json:"status"`
+	UpSince        int64               `json:"up_since"`
+	UpgradePath    []string            `json:"upgrade_path"`
+	WirelessMode   string              `json:"wireless_mode"`
 }
 
 // MakeDeviceJSON transforms a Device into a DeviceJSON
 func MakeDeviceJSON(dev *provisioner.Device) *DeviceJSON {
+	snap := dev.Snapshot()
 	j := &DeviceJSON{
-		CanUpgrade:   dev.CanUpgrade(),
-		Essid:        dev.Essid,
-		Firmware:     dev.Firmware,
-		FirstSeenAt:  dev.FirstSeenAt.Unix(),
-		HasConfig:    dev.HasConfig(),
-		Hostname:     dev.Hostname,
-		IPAddress:    dev.IPAddress,
-		IPAddresses:  make(map[string][]string),
-		LastSeenAt:   dev.LastSeenAt.Unix(),
-		MacAddress:   dev.MacAddress,
-		Model:        dev.Model,
-		Platform:     dev.Platform,
-		Status:       dev.Status(),
-		UpSince:      dev.UpSince.Unix(),
-		WirelessMode: dev.WirelessMode,
-	}
-
-	for mac, ips := range dev.IPAddresses {
+		CanUpgrade:     dev.CanUpgrade(),
+		ConfigRule:     dev.ConfigRule(),
+		ConfigTemplate: dev.ConfigTemplate(),
+		Essid:          snap.Essid,
+		Firmware:       snap.Firmware,
+		FirstSeenAt:    snap.FirstSeenAt.Unix(),
+		HasConfig:      dev.HasConfig(),
+		Hostname:       snap.Hostname,
+		IPAddress:      dev.IPAddress(),
+		IPAddresses:    make(map[string][]string),
+		LastSeenAt:     snap.LastSeenAt.Unix(),
+		MacAddress:     snap.MacAddress,
+		Model:          snap.Model,
+		Platform:       snap.Platform,
+		Status:         dev.Status(),
+		UpSince:        snap.UpSince.Unix(),
+		UpgradePath:    dev.UpgradePath(),
+		WirelessMode:   snap.WirelessMode,
+	}
+
+	if job := dev.CurrentJob(); job != nil {
+		j.JobID = job.ID
+	}
+
+	if key := dev.HostKey(); key != nil {
+		j.HostKey = MakeHostKeyJSON(key)
+	}
+
+	j.Access = MakeAccessJSON(dev)
+
+	if diff, checkedAt, err := dev.Drift(); !checkedAt.IsZero() {
+		j.DriftCheckedAt = checkedAt.Unix()
+		if diff != nil && !diff.Empty() {
+			j.Drift = MakeConfigDiffJSON(diff)
+		}
+		if err != nil {
+			j.DriftError = err.Error()
+		}
+	}
+
+	for mac, ips := range snap.IPAddresses {
 		// copy(j.IPAddresses[mac], ips) // doesn't work
 
 		j.IPAddresses[mac] = make([]string, len(ips), len(ips))
@@ -61,3 +100,401 @@ func WrapDeviceJSON(devices []*provisioner.Device) []*DeviceJSON {
 	}
 	return list
 }
+
+// JobJSON wraps a provisioner.Job into JSON presentation
+type JobJSON struct {
+	CreatedAt  int64           `json:"created_at"`
+	Error      string          `json:"error,omitempty"`
+	Exec       *ExecResultJSON `json:"exec,omitempty"`
+	FinishedAt int64           `json:"finished_at,omitempty"`
+	ID         uint64          `json:"id"`
+	Log        []string        `json:"log"`
+	MacAddress string          `json:"mac_address"`
+	Progress   *ProgressJSON   `json:"progress,omitempty"`
+	Result     string          `json:"result,omitempty"`
+	StartedAt  int64           `json:"started_at,omitempty"`
+	State      string          `json:"state"`
+	Type       string          `json:"type"`
+}
+
+// MakeJobJSON transforms a Job into a JobJSON
+func MakeJobJSON(job *provisioner.Job) *JobJSON {
+	j := &JobJSON{
+		CreatedAt:  job.CreatedAt.Unix(),
+		FinishedAt: unixOrZero(job.FinishedAt()),
+		ID:         job.ID,
+		Log:        job.Log(),
+		MacAddress: job.MacAddress,
+		Result:     job.Result(),
+		StartedAt:  unixOrZero(job.StartedAt()),
+		State:      string(job.State()),
+		Type:       job.Type,
+	}
+	if err := job.Err(); err != nil {
+		j.Error = err.Error()
+	}
+	if res := job.ExecResult(); res != nil {
+		j.Exec = MakeExecResultJSON(res)
+	}
+	if p := job.Progress(); p != nil {
+		j.Progress = &ProgressJSON{Bytes: p.Bytes, Total: p.Total, Rate: p.Rate}
+	}
+	return j
+}
+
+// ProgressJSON describes the progress of a file transfer
+type ProgressJSON struct {
+	Bytes int64   `json:"bytes"`
+	Total int64   `json:"total"`
+	Rate  float64 `json:"rate"` // bytes per second
+}
+
+// WrapJobJSON transforms a list of Jobs into a list of JobJSONs
+func WrapJobJSON(jobs []*provisioner.Job) []*JobJSON {
+	list := make([]*JobJSON, len(jobs))
+	for i, job := range jobs {
+		list[i] = MakeJobJSON(job)
+	}
+	return list
+}
+
+// ExecResultJSON wraps the result of a remote command into JSON
+// presentation
+type ExecResultJSON struct {
+	Command  string  `json:"command"`
+	Duration float64 `json:"duration"` // seconds
+	ExitCode int     `json:"exit_code"`
+	Stderr   string  `json:"stderr"`
+	Stdout   string  `json:"stdout"`
+}
+
+// MakeExecResultJSON transforms a Result into an ExecResultJSON
+func MakeExecResultJSON(res *pssh.Result) *ExecResultJSON {
+	return &ExecResultJSON{
+		Command:  res.Command,
+		Duration: res.Duration.Seconds(),
+		ExitCode: res.ExitCode,
+		Stderr:   string(res.Stderr),
+		Stdout:   string(res.Stdout),
+	}
+}
+
+// LogLineJSON wraps a provisioner.LogLine into JSON presentation
+type LogLineJSON struct {
+	Message string `json:"message"`
+	Time    int64  `json:"t"`
+}
+
+// WrapLogLineJSON transforms a list of LogLines into a list of LogLineJSONs
+func WrapLogLineJSON(lines []provisioner.LogLine) []*LogLineJSON {
+	list := make([]*LogLineJSON, len(lines))
+	for i, l := range lines {
+		list[i] = &LogLineJSON{Message: l.Message, Time: l.Time.Unix()}
+	}
+	return list
+}
+
+// BulkJSON wraps a provisioner.Bulk into JSON presentation
+type BulkJSON struct {
+	Action     string            `json:"action"`
+	BatchDelay string            `json:"batch_delay"`
+	Devices    []*BulkResultJSON `json:"devices"`
+	CreatedAt  int64             `json:"created_at"`
+	FinishedAt int64             `json:"finished_at,omitempty"`
+	ID         uint64            `json:"id"`
+	Options    map[string]int    `json:"options"`
+	Progress   map[string]int    `json:"progress"`
+	State      string            `json:"state"`
+}
+
+// BulkResultJSON wraps a provisioner.BulkResult into JSON presentation
+type BulkResultJSON struct {
+	Error      string `json:"error,omitempty"`
+	JobID      uint64 `json:"job_id,omitempty"`
+	MacAddress string `json:"mac_address"`
+	State      string `json:"state"`
+}
+
+// MakeBulkJSON transforms a Bulk into a BulkJSON
+func MakeBulkJSON(b *provisioner.Bulk) *BulkJSON {
+	p := b.Progress()
+	maxFailures := 0
+	if m := b.Options.MaxFailures; m != nil {
+		maxFailures = *m
+	}
+	j := &BulkJSON{
+		Action:     b.Action,
+		BatchDelay: b.Options.BatchDelay.String(),
+		CreatedAt:  b.CreatedAt.Unix(),
+		FinishedAt: unixOrZero(b.FinishedAt()),
+		ID:         b.ID,
+		Options: map[string]int{
+			"concurrency":  b.Options.Concurrency,
+			"max_failures": maxFailures,
+		},
+		Progress: map[string]int{
+			"total":     p.Total,
+			"pending":   p.Pending,
+			"running":   p.Running,
+			"succeeded": p.Succeeded,
+			"failed":    p.Failed,
+			"skipped":   p.Skipped,
+		},
+		State: string(b.State()),
+	}
+
+	for _, r := range b.Results() {
+		res := &BulkResultJSON{MacAddress: r.MacAddress, State: "pending"}
+		switch {
+		case r.Skipped:
+			res.State = "skipped"
+		case r.Err != nil:
+			res.State = string(provisioner.JobFailed)
+			res.Error = r.Err.Error()
+		case r.Job != nil:
+			res.JobID = r.Job.ID
+			res.State = string(r.Job.State())
+			if err := r.Job.Err(); err != nil {
+				res.Error = err.Error()
+			}
+		}
+		j.Devices = append(j.Devices, res)
+	}
+	return j
+}
+
+// WrapBulkJSON transforms a list of Bulks into a list of BulkJSONs
+func WrapBulkJSON(bulks []*provisioner.Bulk) []*BulkJSON {
+	list := make([]*BulkJSON, len(bulks))
+	for i, b := range bulks {
+		list[i] = MakeBulkJSON(b)
+	}
+	return list
+}
+
+// ConfigChangeJSON wraps a syscfg.Change into JSON presentation
+type ConfigChangeJSON struct {
+	Key string `json:"key"`
+	New string `json:"new,omitempty"`
+	Old string `json:"old,omitempty"`
+}
+
+// ConfigDiffJSON wraps a syscfg.Diff into JSON presentation
+type ConfigDiffJSON struct {
+	Added   []ConfigChangeJSON `json:"added"`
+	Changed []ConfigChangeJSON `json:"changed"`
+	Removed []ConfigChangeJSON `json:"removed"`
+}
+
+// MakeConfigDiffJSON transforms a Diff into a ConfigDiffJSON
+func MakeConfigDiffJSON(diff *syscfg.Diff) *ConfigDiffJSON {
+	wrap := func(changes []syscfg.Change) []ConfigChangeJSON {
+		list := make([]ConfigChangeJSON, len(changes))
+		for i, c := range changes {
+			list[i] = ConfigChangeJSON{Key: c.Key, New: c.New, Old: c.Old}
+		}
+		return list
+	}
+	return &ConfigDiffJSON{
+		Added:   wrap(diff.Added),
+		Changed: wrap(diff.Changed),
+		Removed: wrap(diff.Removed),
+	}
+}
+
+// PatchPreviewJSON wraps a provisioner.PatchPreview into JSON presentation
+type PatchPreviewJSON struct {
+	Diff       *ConfigDiffJSON `json:"diff,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	MacAddress string          `json:"mac_address"`
+}
+
+// WrapPatchPreviewJSON transforms a list of PatchPreviews into a list of
+// PatchPreviewJSONs
+func WrapPatchPreviewJSON(previews []provisioner.PatchPreview) []*PatchPreviewJSON {
+	list := make([]*PatchPreviewJSON, len(previews))
+	for i, p := range previews {
+		list[i] = &PatchPreviewJSON{MacAddress: p.MacAddress}
+		if p.Diff != nil {
+			list[i].Diff = MakeConfigDiffJSON(p.Diff)
+		}
+		if p.Err != nil {
+			list[i].Error = p.Err.Error()
+		}
+	}
+	return list
+}
+
+// AccessJSON describes the outcome of the last SSH connection attempt
+type AccessJSON struct {
+	Status    string `json:"status"`
+	Method    string `json:"method,omitempty"`
+	CheckedAt int64  `json:"checked_at"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MakeAccessJSON returns the access state of a device, or nil, if no
+// connection attempt was made yet
+func MakeAccessJSON(dev *provisioner.Device) *AccessJSON {
+	status, method, checkedAt, err := dev.Access()
+	if status == provisioner.AccessUnknown {
+		return nil
+	}
+	j := &AccessJSON{
+		Status:    status,
+		Method:    method,
+		CheckedAt: checkedAt.Unix(),
+	}
+	if err != nil {
+		j.Error = err.Error()
+	}
+	return j
+}
+
+// HostKeyJSON wraps a provisioner.HostKey into JSON presentation
+type HostKeyJSON struct {
+	Fingerprint string `json:"fingerprint"`
+	MacAddress  string `json:"mac_address"`
+	Type        string `json:"type"`
+}
+
+// MakeHostKeyJSON transforms a HostKey into a HostKeyJSON
+func MakeHostKeyJSON(key *provisioner.HostKey) *HostKeyJSON {
+	return &HostKeyJSON{
+		Fingerprint: key.Fingerprint,
+		MacAddress:  key.MacAddress,
+		Type:        key.Type,
+	}
+}
+
+// WrapHostKeyJSON transforms a list of HostKeys into a list of HostKeyJSONs
+func WrapHostKeyJSON(keys []*provisioner.HostKey) []*HostKeyJSON {
+	list := make([]*HostKeyJSON, len(keys))
+	for i, key := range keys {
+		list[i] = MakeHostKeyJSON(key)
+	}
+	return list
+}
+
+// BackupJSON wraps a provisioner.Backup into JSON presentation
+type BackupJSON struct {
+	CreatedAt int64  `json:"created_at"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+}
+
+// WrapBackupJSON transforms a list of Backups into a list of BackupJSONs
+func WrapBackupJSON(backups []*provisioner.Backup) []*BackupJSON {
+	list := make([]*BackupJSON, len(backups))
+	for i, b := range backups {
+		list[i] = &BackupJSON{
+			CreatedAt: b.CreatedAt.Unix(),
+			Name:      b.Name,
+			Size:      b.Size,
+		}
+	}
+	return list
+}
+
+// FirmwareJSON wraps a provisioner.FirmwareFile into JSON presentation
+type FirmwareJSON struct {
+	Board      string           `json:"board,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	ModTime    int64            `json:"mod_time"`
+	Name       string           `json:"name"`
+	Partitions []*PartitionJSON `json:"partitions,omitempty"`
+	Platform   string           `json:"platform,omitempty"`
+	SHA256     string           `json:"sha256,omitempty"`
+	Signed     bool             `json:"signed"`
+	Size       int64            `json:"size"`
+	Version    string           `json:"version,omitempty"`
+}
+
+// PartitionJSON describes a partition of a firmware image
+type PartitionJSON struct {
+	CRC      uint32 `json:"crc"`
+	DataSize uint32 `json:"data_size"`
+	Index    uint32 `json:"index"`
+	Name     string `json:"name"`
+	PartSize uint32 `json:"part_size"`
+}
+
+// MakeFirmwareJSON transforms a FirmwareFile into a FirmwareJSON
+func MakeFirmwareJSON(f *provisioner.FirmwareFile) *FirmwareJSON {
+	j := &FirmwareJSON{
+		ModTime: unixOrZero(f.ModTime),
+		Name:    f.Name,
+		SHA256:  f.SHA256,
+		Size:    f.Size,
+	}
+	if f.Err != nil {
+		j.Error = f.Err.Error()
+	}
+	if img := f.Image; img != nil {
+		j.Board = img.Board
+		j.Platform = img.Platform
+		j.Signed = img.Signed
+		j.Version = img.Version
+		for _, p := range img.Partitions {
+			j.Partitions = append(j.Partitions, &PartitionJSON{
+				CRC:      p.CRC,
+				DataSize: p.DataSize,
+				Index:    p.Index,
+				Name:     p.Name,
+				PartSize: p.PartSize,
+			})
+		}
+	}
+	return j
+}
+
+// WrapFirmwareJSON transforms a list of FirmwareFiles into a list of
+// FirmwareJSONs
+func WrapFirmwareJSON(files []*provisioner.FirmwareFile) []*FirmwareJSON {
+	list := make([]*FirmwareJSON, len(files))
+	for i, f := range files {
+		list[i] = MakeFirmwareJSON(f)
+	}
+	return list
+}
+
+// EventJSON wraps a provisioner.Event into JSON presentation
+type EventJSON struct {
+	Bulk    *BulkJSON   `json:"bulk,omitempty"`
+	Device  *DeviceJSON `json:"device,omitempty"`
+	Job     *JobJSON    `json:"job,omitempty"`
+	JobID   uint64      `json:"job_id,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Time    int64       `json:"t"`
+	Type    string      `json:"type"`
+}
+
+// MakeEventJSON transforms an Event into an EventJSON. For log events,
+// only the job ID is included.
+func MakeEventJSON(e *provisioner.Event) *EventJSON {
+	j := &EventJSON{
+		Message: e.Message,
+		Time:    e.Time.Unix(),
+		Type:    string(e.Type),
+	}
+	if e.Bulk != nil {
+		j.Bulk = MakeBulkJSON(e.Bulk)
+	}
+	if e.Device != nil {
+		j.Device = MakeDeviceJSON(e.Device)
+	}
+	if e.Job != nil {
+		j.JobID = e.Job.ID
+		if e.Type != provisioner.EventJobLog {
+			j.Job = MakeJobJSON(e.Job)
+		}
+	}
+	return j
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
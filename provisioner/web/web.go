@@ -24,6 +24,7 @@ const (
 
 	contentTypeHTML = "text/html; charset=UTF-8"
 	contentTypeJSON = "application/json; charset=UTF-8"
+	contentTypeText = "text/plain; charset=UTF-8"
 )
 
 type goWeb struct {
@@ -57,7 +58,7 @@ func (g *goWeb) buildRoutes() {
 	g.router.PathPrefix("/assets/").HandlerFunc(g.getStaticAsset).Name("asset")
 	g.router.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
 		routes := make(map[string]string)
-		for _, name := range []string{"api_directory", "device", "devices", "upgrade_device", "provision_device", "reboot_device"} {
+		for _, name := range []string{"api_directory", "device", "devices", "upgrade_device", "provision_device", "reboot_device", "device_log", "device_log_stream", "device_config_diff", "device_config_rendered", "device_backups", "device_backup", "restore_backup", "check_access", "exec_device", "exec_commands", "device_host_key", "forget_host_key", "host_keys", "jobs", "job", "cancel_job", "bulks", "bulk", "start_bulk", "start_bulk_exec", "patch", "cancel_bulk", "firmwares", "events", "reload_config"} {
 			route := g.router.Get(name)
 			if tpl, err := route.GetPathTemplate(); err == nil {
 				routes[name] = fmt.Sprintf("//%s%s", g.server.Addr, tpl)
@@ -73,6 +74,37 @@ func (g *goWeb) buildRoutes() {
 	dev.HandleFunc("/{mac}/upgrade", g.upgradeDevice).Methods("POST").Name("upgrade_device")
 	dev.HandleFunc("/{mac}/provision", g.provisionDevice).Methods("POST").Name("provision_device")
 	dev.HandleFunc("/{mac}/reboot", g.rebootDevice).Methods("POST").Name("reboot_device")
+	dev.HandleFunc("/{mac}/log", g.getDeviceLog).Methods("GET").Name("device_log")
+	dev.HandleFunc("/{mac}/log/stream", g.streamDeviceLog).Methods("GET").Name("device_log_stream")
+	dev.HandleFunc("/{mac}/config/diff", g.getConfigDiff).Methods("GET").Name("device_config_diff")
+	dev.HandleFunc("/{mac}/config/rendered", g.getRenderedConfig).Methods("GET").Name("device_config_rendered")
+	dev.HandleFunc("/{mac}/exec", g.execDevice).Methods("POST").Name("exec_device")
+	dev.HandleFunc("/{mac}/check-access", g.checkAccess).Methods("POST").Name("check_access")
+	dev.HandleFunc("/{mac}/host-key", g.getHostKey).Methods("GET").Name("device_host_key")
+	dev.HandleFunc("/{mac}/host-key", g.forgetHostKey).Methods("DELETE").Name("forget_host_key")
+	dev.HandleFunc("/{mac}/backups", g.getBackups).Methods("GET").Name("device_backups")
+	dev.HandleFunc("/{mac}/backups/{name}", g.getBackup).Methods("GET").Name("device_backup")
+	dev.HandleFunc("/{mac}/backups/{name}/restore", g.restoreBackup).Methods("POST").Name("restore_backup")
+
+	g.router.HandleFunc("/api/bulk", g.getBulks).Methods("GET").Name("bulks")
+	bulk := g.router.PathPrefix("/api/bulk").Subrouter()
+	bulk.HandleFunc("/{id:[0-9]+}", g.getBulk).Methods("GET").Name("bulk")
+	bulk.HandleFunc("/{id:[0-9]+}", g.cancelBulk).Methods("DELETE").Name("cancel_bulk")
+	bulk.HandleFunc("/exec", g.startBulkExec).Methods("POST").Name("start_bulk_exec")
+	bulk.HandleFunc("/{action:upgrade|provision|reboot}", g.startBulk).Methods("POST").Name("start_bulk")
+
+	g.router.HandleFunc("/api/patch", g.patchDevices).Methods("POST").Name("patch")
+
+	g.router.HandleFunc("/api/exec/commands", g.getExecCommands).Methods("GET").Name("exec_commands")
+	g.router.HandleFunc("/api/host-keys", g.getHostKeys).Methods("GET").Name("host_keys")
+	g.router.HandleFunc("/api/firmwares", g.getFirmwares).Methods("GET").Name("firmwares")
+	g.router.HandleFunc("/api/events", g.getEvents).Methods("GET").Name("events")
+	g.router.HandleFunc("/api/config/reload", g.reloadConfig).Methods("POST").Name("reload_config")
+
+	g.router.HandleFunc("/api/jobs", g.getJobs).Methods("GET").Name("jobs")
+	job := g.router.PathPrefix("/api/jobs").Subrouter()
+	job.HandleFunc("/{id:[0-9]+}", g.getJob).Methods("GET").Name("job")
+	job.HandleFunc("/{id:[0-9]+}", g.cancelJob).Methods("DELETE").Name("cancel_job")
 }
 
 func (g *goWeb) statusJSON(w http.ResponseWriter, status int, message string, v ...interface{}) {
@@ -90,6 +122,14 @@ func (g *goWeb) statusJSON(w http.ResponseWriter, status int, message string, v
 	g.responseJSON(w, status, json)
 }
 
+func (g *goWeb) jobJSON(w http.ResponseWriter, job *provisioner.Job, message string, v ...interface{}) {
+	g.responseJSON(w, http.StatusAccepted, map[string]interface{}{
+		"type":    "success",
+		"message": fmt.Sprintf(message, v...),
+		"job_id":  job.ID,
+	})
+}
+
 func (g *goWeb) responseJSON(w http.ResponseWriter, status int, v interface{}) {
 	if result, err := json.Marshal(v); err != nil {
 		log.Printf("[responseJSON error] %v", err)
@@ -97,7 +137,7 @@ func (g *goWeb) responseJSON(w http.ResponseWriter, status int, v interface{}) {
 	} else {
 		w.Header().Set(headerContentType, contentTypeJSON)
 		w.Header().Set(headerContentLength, strconv.Itoa(len(result)))
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(status)
 		w.Write(result)
 	}
 }
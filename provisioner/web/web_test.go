@@ -0,0 +1,111 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digineo/ubnt-tools/provisioner"
+	"github.com/stretchr/testify/assert"
+)
+
+func testWeb(t *testing.T) *goWeb {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.yml")
+	content := "config_directory: ./\nfirmware_directory: ./\ninterfaces: [eth0]\nweb: {port: 8080}\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, errs := provisioner.LoadConfig(fileName)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	g := &goWeb{config: c, server: &http.Server{}}
+	g.buildRoutes()
+	return g
+}
+
+func TestJobJSONStatus(t *testing.T) {
+	assert := assert.New(t)
+	g := testWeb(t)
+
+	w := httptest.NewRecorder()
+	g.jobJSON(w, &provisioner.Job{ID: 42}, "Job %d enqueued.", 42)
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	assert.EqualValues(42, body["job_id"])
+	assert.Equal("success", body["type"])
+}
+
+func TestHandlerStatus(t *testing.T) {
+	assert := assert.New(t)
+	g := testWeb(t)
+
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/jobs/42", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	g.router.ServeHTTP(w, httptest.NewRequest("GET", "/api/jobs", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	// an empty filter must not select all devices
+	w = httptest.NewRecorder()
+	g.router.ServeHTTP(w, httptest.NewRequest("POST", "/api/bulk/reboot", strings.NewReader(`{"filter":{}}`)))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestGetEvents(t *testing.T) {
+	assert := assert.New(t)
+	g := testWeb(t)
+
+	srv := httptest.NewServer(g.router)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/events")
+	if !assert.NoError(err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(contentTypeEventStream, res.Header.Get(headerContentType))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(time.Second):
+			return "(timeout)"
+		}
+	}
+
+	assert.Equal(": connected", next())
+	assert.Equal("", next())
+
+	g.config.Reload()
+	assert.Equal("event: config-reload", next())
+	data := next()
+	if assert.True(strings.HasPrefix(data, "data: "), data) {
+		var e EventJSON
+		assert.NoError(json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &e))
+		assert.Equal("config-reload", e.Type)
+		assert.Equal("Configuration reloaded", e.Message)
+	}
+	assert.Equal("", next())
+}